@@ -0,0 +1,142 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncWriter is an io.Writer that hands writes to its own goroutine through
+// a buffer, so a slow or flaky destination, such as a network writer, doesn't
+// hold up the writer goroutine and with it all logging. Writes that don't fit
+// in the buffer are dropped and counted in Drops. Use it as an output, for
+// instance with SetWriter or AddSink; Flush and Close of the package wait for
+// it to catch up.
+type AsyncWriter struct {
+	w       io.Writer
+	pending chan asyncWrite
+	done    chan struct{}
+	drops   uint64 // atomic
+
+	mu     sync.RWMutex // guards closed against writes racing Close
+	closed bool
+}
+
+// asyncWrite is a write for the AsyncWriter goroutine, or a flush request
+// when flushed is set.
+type asyncWrite struct {
+	p       []byte
+	flushed chan struct{}
+}
+
+// NewAsyncWriter returns an AsyncWriter writing to w, buffering up to size
+// writes.
+func NewAsyncWriter(w io.Writer, size int) *AsyncWriter {
+	if size < 1 {
+		size = 1
+	}
+	aw := &AsyncWriter{
+		w:       w,
+		pending: make(chan asyncWrite, size),
+		done:    make(chan struct{}),
+	}
+	go aw.run()
+	return aw
+}
+
+func (aw *AsyncWriter) run() {
+	defer close(aw.done)
+	for pw := range aw.pending {
+		if pw.flushed != nil {
+			close(pw.flushed)
+			continue
+		}
+		if _, err := aw.w.Write(pw.p); err != nil {
+			atomic.AddUint64(&errCount, 1)
+		}
+	}
+}
+
+// Write queues a copy of p to be written. It never blocks: when the buffer
+// is full, or the AsyncWriter is closed, p is dropped and counted in Drops.
+func (aw *AsyncWriter) Write(p []byte) (int, error) {
+	aw.mu.RLock()
+	defer aw.mu.RUnlock()
+	if !aw.closed {
+		select {
+		case aw.pending <- asyncWrite{p: append([]byte(nil), p...)}:
+			return len(p), nil
+		default:
+		}
+	}
+	atomic.AddUint64(&aw.drops, 1)
+	return len(p), nil
+}
+
+// Drops returns the number of writes dropped because the buffer was full.
+func (aw *AsyncWriter) Drops() uint64 {
+	return atomic.LoadUint64(&aw.drops)
+}
+
+// Flush blocks until every write queued before it has been written, or the
+// context is canceled.
+func (aw *AsyncWriter) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	aw.mu.RLock()
+	if aw.closed {
+		aw.mu.RUnlock()
+		return nil // Close has written everything
+	}
+	select {
+	case aw.pending <- asyncWrite{flushed: flushed}:
+		aw.mu.RUnlock()
+	case <-ctx.Done():
+		aw.mu.RUnlock()
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close writes out the buffered writes and stops the goroutine. The
+// underlying writer is closed too if it is an io.Closer. Later writes are
+// dropped.
+func (aw *AsyncWriter) Close() error {
+	aw.mu.Lock()
+	if aw.closed {
+		aw.mu.Unlock()
+		return nil
+	}
+	aw.closed = true
+	close(aw.pending)
+	aw.mu.Unlock()
+
+	<-aw.done
+	if c, ok := aw.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// flusher is implemented by outputs that buffer writes, such as AsyncWriter.
+type flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// flushOutputs flushes the outputs in ws that implement flusher.
+func flushOutputs(ctx context.Context, ws []io.Writer) (err error) {
+	for _, w := range ws {
+		if f, ok := w.(flusher); ok {
+			if ferr := f.Flush(ctx); ferr != nil && err == nil {
+				err = ferr
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsyncWriter(t *testing.T) {
+	slow := &blockingWriter{release: make(chan struct{})}
+	aw := NewAsyncWriter(slow, 2)
+
+	// one write is taken by the goroutine and blocks, two are buffered
+	aw.Write([]byte("x\n"))
+	for len(aw.pending) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 4; i++ {
+		aw.Write([]byte("x\n"))
+	}
+	if drops := aw.Drops(); drops != 2 {
+		t.Errorf("expected 2 drops with a full buffer but got %d", drops)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := aw.Flush(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected Flush to time out on a blocked writer but got %v", err)
+	}
+
+	close(slow.release)
+	if err := aw.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := slow.String(); got != "x\nx\nx\n" {
+		t.Errorf("expected the 3 writes that fit to be written but got %q", got)
+	}
+
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	aw.Write([]byte("late\n"))
+	if err := aw.Close(); err != nil || aw.Drops() != 3 || strings.Contains(slow.String(), "late") {
+		t.Errorf("expected writes after Close to be dropped, got %d drops and %q", aw.Drops(), slow.String())
+	}
+}
+
+func TestAsyncWriterOutput(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	slow := &blockingWriter{release: make(chan struct{})}
+	aw := NewAsyncWriter(slow, 10)
+	defer aw.Close()
+	SetWriter(aw)
+
+	log := New(Levels.Info)
+	log.Infof("", "first")
+	Drain() // the logger's writer isn't held up by the slow output
+	log.Infof("", "second")
+
+	close(slow.release)
+	if err := Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := slow.String(); !strings.Contains(got, "first") || !strings.Contains(got, "second") {
+		t.Errorf("expected Flush to wait for the async output but got %q", got)
+	}
+}
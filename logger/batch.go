@@ -0,0 +1,67 @@
+package logger
+
+import "time"
+
+// defaultSocketBatchInterval is how long a batch may wait when
+// SetSocketBatching is given no interval.
+const defaultSocketBatchInterval = 100 * time.Millisecond
+
+var (
+	// socketBatchSize and socketBatchInterval bound the socket batch; see
+	// SetSocketBatching
+	socketBatchSize     int
+	socketBatchInterval time.Duration
+
+	// socketBatch holds the framed messages waiting to be written to the
+	// custom socket, socketBatchCount of them. Only used by the writer
+	// goroutine.
+	socketBatch      []byte
+	socketBatchCount int
+)
+
+// SetSocketBatching makes the writer collect up to n messages for the custom
+// socket and write them with a single write, once n are collected or the
+// oldest has waited t, whichever comes first. Each message keeps its own
+// framing within the batch. Datagram sockets still get a write per message,
+// since a datagram holds a single one, unless they are octet counted; see
+// SetSyslogFraming. A t of zero waits up to 100ms, and an n of 0 or 1 turns
+// batching off, which is the default. Batches are written out by Flush and
+// Close, and retried like single messages; see SetWriteRetries. It should
+// be called before logging starts.
+func SetSocketBatching(n int, t time.Duration) {
+	if t <= 0 {
+		t = defaultSocketBatchInterval
+	}
+	socketBatchSize, socketBatchInterval = n, t
+}
+
+// socketBatching reports whether messages to the custom socket are batched:
+// frames only stay apart within a write on stream sockets or when octet
+// counted.
+func socketBatching() bool {
+	if socketBatchSize <= 1 {
+		return false
+	}
+	return octetCounted() || streamNetwork(customSockNetwork)
+}
+
+// batchSocketMsg adds a message to the socket batch, writing the batch out
+// once it is full.
+func batchSocketMsg(msg *logMessage) error {
+	socketBatch = append(socketBatch, customSocketFrame(msg)...)
+	socketBatchCount++
+	if socketBatchCount >= socketBatchSize {
+		return flushSocketBatch()
+	}
+	return nil
+}
+
+// flushSocketBatch writes the socket batch out, if any.
+func flushSocketBatch() error {
+	if socketBatchCount == 0 {
+		return nil
+	}
+	err := retryWrite(func() error { return writeCustomSocketFrames(socketBatch) }, uint64(socketBatchCount))
+	socketBatch, socketBatchCount = socketBatch[:0], 0
+	return err
+}
@@ -0,0 +1,148 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordingConn is a net.Conn recording each write.
+type recordingConn struct {
+	net.Conn
+	mu     sync.Mutex
+	writes [][]byte
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes = append(c.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (c *recordingConn) Close() error { return nil }
+
+func (c *recordingConn) Writes() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]byte(nil), c.writes...)
+}
+
+func TestSetSocketBatching(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer func(sock net.Conn, network string) {
+		customSock, customSockNetwork = sock, network
+		atomic.StoreInt32(&customSockConnected, 0)
+	}(customSock, customSockNetwork)
+	defer SetSocketBatching(0, 0)
+	defer SetSyslogLegacyFormat(false)
+
+	conn := &recordingConn{}
+	stdhdl, customSock, customSockNetwork = nil, conn, "tcp"
+	atomic.StoreInt32(&customSockConnected, 1)
+	SetSyslogLegacyFormat(true)
+	SetSocketBatching(3, time.Hour)
+
+	log := New(Levels.Info)
+	for i := 0; i < 4; i++ {
+		log.Infof("", "m")
+	}
+	Drain()
+	writes := conn.Writes()
+	if len(writes) != 1 || bytes.Count(writes[0], []byte("<14>")) != 3 {
+		t.Fatalf("expected one write of 3 messages but got %q", writes)
+	}
+	if !regexp.MustCompile(`^\d+ <14>`).Match(writes[0]) {
+		t.Errorf("expected octet counted frames in the batch but got %q", writes[0])
+	}
+
+	if err := Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if writes = conn.Writes(); len(writes) != 2 || bytes.Count(writes[1], []byte("<14>")) != 1 {
+		t.Fatalf("expected Flush to write the last message but got %q", writes)
+	}
+
+	SetSocketBatching(3, 10*time.Millisecond)
+	log.Infof("", "m")
+	deadline := time.Now().Add(time.Second)
+	for len(conn.Writes()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if writes = conn.Writes(); len(writes) != 3 {
+		t.Errorf("expected the batch to be written after its interval but got %q", writes)
+	}
+}
+
+func TestSocketBatchingDatagram(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer func(sock net.Conn, network string) {
+		customSock, customSockNetwork = sock, network
+		atomic.StoreInt32(&customSockConnected, 0)
+	}(customSock, customSockNetwork)
+	defer SetSocketBatching(0, 0)
+	defer SetSyslogFraming(SyslogFramingAuto)
+
+	conn := &recordingConn{}
+	stdhdl, customSock, customSockNetwork = nil, conn, "udp"
+	atomic.StoreInt32(&customSockConnected, 1)
+	SetSocketBatching(3, time.Hour)
+
+	log := New(Levels.Info)
+	for i := 0; i < 3; i++ {
+		log.Infof("", "m")
+	}
+	Drain()
+	if writes := conn.Writes(); len(writes) != 3 {
+		t.Fatalf("expected a datagram per message but got %q", writes)
+	}
+
+	SetSyslogFraming(SyslogFramingOctetCounted)
+	for i := 0; i < 3; i++ {
+		log.Infof("", "m")
+	}
+	Drain()
+	if writes := conn.Writes(); len(writes) != 4 {
+		t.Errorf("expected octet counted messages to be batched but got %q", writes)
+	}
+}
+
+// failingConn is a net.Conn whose writes all fail.
+type failingConn struct{ net.Conn }
+
+func (failingConn) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func (failingConn) Close() error { return nil }
+
+func TestSocketBatchRetries(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer func(sock net.Conn, network, address string) {
+		customSock, customSockNetwork, customSockAddress = sock, network, address
+		atomic.StoreInt32(&customSockConnected, 0)
+		redialBackoff, redialAt = 0, time.Time{}
+	}(customSock, customSockNetwork, customSockAddress)
+	defer SetSocketBatching(0, 0)
+	defer SetWriteRetries(0, 0)
+
+	// the socket can't be redialed, so every attempt fails
+	stdhdl, customSock, customSockNetwork, customSockAddress = nil, failingConn{}, "tcp", ""
+	atomic.StoreInt32(&customSockConnected, 1)
+	SetSocketBatching(3, time.Hour)
+	SetWriteRetries(1, time.Millisecond)
+
+	_, _, dropsBefore, _ := Stats()
+	log := New(Levels.Info)
+	for i := 0; i < 3; i++ {
+		log.Infof("", "m")
+	}
+	Drain()
+	if _, _, drops, _ := Stats(); drops-dropsBefore != 3 {
+		t.Errorf("expected the 3 messages of the failed batch to be dropped but got %d", drops-dropsBefore)
+	}
+}
@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// bytesMessages holds the messages of LogBytes, which come from outside
+	// the fixed set so that they don't compete with other logging for it
+	bytesMessages = sync.Pool{New: func() interface{} { return &logMessage{spare: true} }}
+
+	// bytesInFlight is the number of LogBytes messages queued or being
+	// written, at most poolSize; atomic
+	bytesInFlight int32
+)
+
+// LogBytes logs b as the message text, without formatting it, at level.
+// It is a low level escape hatch for performance critical paths that
+// render their own messages: it skips fmt and caller lookup, and its
+// messages don't take from the fixed set of NumMessages shared by the rest
+// of the process. Instead, up to as many LogBytes messages as the fixed set
+// holds can be queued at once, and more are dropped. Messages are still
+// rendered in the configured format, with the prefix, the logger fields and
+// the static fields, and teed.
+//
+// b is copied before LogBytes returns, so callers may reuse it, for
+// instance by taking their buffers from a sync.Pool of their own and
+// putting them back right after. LogBytes never waits, whatever the sync
+// level. Prefer the Printf-style methods everywhere else.
+func (l *Logger) LogBytes(level Level, prefix string, b []byte) error {
+	if !l.keep(level) {
+		return nil
+	}
+	if l.name != "" {
+		prefix = l.name + " " + prefix
+	}
+
+	atomic.AddUint64(&l.logCount, 1)
+	err := queueBytes(&logEntry{lvl: level, pre: prefix, tee: true, fields: l.fields, out: l.out}, b)
+	if err == ErrMessageDropped {
+		atomic.AddUint64(&l.dropCount, 1)
+	}
+	return err
+}
+
+// queueBytes renders a LogBytes message with b as the message text and
+// queues it for the writer goroutine.
+func queueBytes(le *logEntry, b []byte) error {
+	countLog(le.lvl)
+	if inWriter() {
+		le.raw = b
+		_ = writeRecursive(le)
+		return ErrLogRecursion
+	}
+	pool, _, err := currentPool()
+	if err == ErrLoggerClosed {
+		panic(err) // see Close
+	}
+	if err == nil && atomic.AddInt32(&bytesInFlight, 1) > int32(cap(pool)) {
+		atomic.AddInt32(&bytesInFlight, -1)
+		err = ErrMessageDropped
+	}
+	if err != nil {
+		countDrop(le) // with no format
+		return ErrMessageDropped
+	}
+
+	msg := bytesMessages.Get().(*logMessage)
+	msg.raw = append(msg.raw[:0], b...)
+	msg.le = *le
+	msg.le.raw = msg.raw
+	if err := render(msg); err != nil {
+		atomic.AddUint64(&errCount, 1)
+		_ = freeMsg(msg)
+		return err
+	}
+	if teeMsg(le) {
+		_ = writeTee(msg) // counted in teeDropCount
+	}
+
+	// there is room for poolSize of them besides the fixed set
+	if err := sendMsg(msg, nil); err != nil {
+		if err == ErrMessageDropped {
+			countDrop(le)
+		}
+		return err
+	}
+	return nil
+}
+
+// freeBytesMsg puts a LogBytes message back into bytesMessages once freeMsg
+// has reset it.
+func freeBytesMsg(msg *logMessage) {
+	if cap(msg.raw) > reclaimThreshold {
+		msg.raw = nil
+	}
+	bytesMessages.Put(msg)
+	if atomic.AddInt32(&bytesInFlight, -1) == 0 && len(freeMessages) >= int(atomic.LoadInt32(&poolFilled)) {
+		notifyDrained()
+	}
+}
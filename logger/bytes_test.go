@@ -0,0 +1,60 @@
+package logger
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"testing"
+)
+
+func TestLogBytes(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	b := []byte("raw message")
+	log := New(Levels.Info).WithFields(Field{"k", "v"})
+	if err := log.LogBytes(Levels.Info, "[raw] ", b); err != nil {
+		t.Fatal(err)
+	}
+	copy(b, "overwritten")
+	_ = log.LogBytes(Levels.Debug, "[raw] ", b)
+	Drain()
+
+	if !regexp.MustCompile(`^\S+ \[Info\] \[raw\] raw message k=v\n$`).MatchString(buf.String()) {
+		t.Errorf("expected one message with the bytes as they were logged but got %q", buf.String())
+	}
+}
+
+func TestLogBytesPool(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer Configure(NumMessages)
+	Configure(2)
+	w := &blockingWriter{release: make(chan struct{})}
+	stdhdl = w
+
+	log := New(Levels.Info)
+	for i := 0; i < 2; i++ {
+		if err := log.LogBytes(Levels.Info, "", []byte("bytes")); err != nil {
+			t.Errorf("expected LogBytes message %d to be queued but got %v", i, err)
+		}
+	}
+	if err := log.LogBytes(Levels.Info, "", []byte("bytes")); err != ErrMessageDropped {
+		t.Errorf("expected LogBytes to drop beyond the pool size but got %v", err)
+	}
+	log.Infof("", "formatted")
+	log.Infof("", "formatted")
+	close(w.release)
+	Drain()
+
+	if logs, drop := log.Stats(); logs != 5 || drop != 1 {
+		t.Errorf("expected 5 logs and only the LogBytes one dropped but got %d and %d", logs, drop)
+	}
+
+	if n := bytes.Count(w.Bytes(), []byte("bytes\n")); n != 2 {
+		t.Errorf("expected 2 LogBytes messages but got %q", w.String())
+	}
+	if n := bytes.Count(w.Bytes(), []byte("formatted\n")); n != 2 {
+		t.Errorf("expected 2 formatted messages but got %q", w.String())
+	}
+}
@@ -0,0 +1,113 @@
+package logger
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// CEF header values; see SetCEFHeader
+var (
+	cefVendor  = "Kentik"
+	cefProduct string // the log name when empty
+	cefVersion string
+)
+
+// cefSeverity maps levels to CEF severities, from 0 (lowest) to 10.
+var cefSeverity = map[Level]int{
+	Levels.Panic:  10,
+	Levels.Error:  7,
+	Levels.Warn:   5,
+	Levels.Info:   3,
+	Levels.Debug:  1,
+	Levels.Access: 3,
+}
+
+// SetCEFHeader sets the device vendor, product and version of CEF messages,
+// logged with KENTIK_LOG_FMT=cef. The vendor is "Kentik" and the product is
+// the log name by default. Empty arguments keep those defaults.
+func SetCEFHeader(vendor, product, version string) {
+	if vendor == "" {
+		vendor = "Kentik"
+	}
+	cefVendor, cefProduct, cefVersion = vendor, product, version
+}
+
+// asCEF renders the message in the ArcSight Common Event Format:
+//
+//	CEF:0|vendor|product|version|signature ID|name|severity|extensions
+//
+// The level is the signature ID, and the prefix is the event name. The
+// extensions are rt (the time in milliseconds since the epoch), msg, caller
+// and the fields of the message.
+func asCEF(msg *logMessage) error {
+	le := &msg.le
+	product := cefProduct
+	if product == "" {
+		product = logNameString
+	}
+	name := strings.TrimSpace(le.pre)
+	if name == "" {
+		name = le.lvl.String()
+	}
+
+	msg.WriteString("CEF:0|")
+	for _, h := range []string{cefVendor, product, cefVersion, le.lvl.String(), name} {
+		writeCEFHeader(&msg.Buffer, h)
+		msg.WriteByte('|')
+	}
+	msg.WriteString(strconv.Itoa(cefSeverity[le.lvl]))
+	msg.WriteByte('|')
+
+	msg.WriteString("rt=")
+	msg.WriteString(strconv.FormatInt(msg.time.UnixNano()/1e6, 10))
+	writeCEFExtension(&msg.Buffer, Field{"msg", jsonMessage(le)})
+	if le.lc.File != "" {
+		writeCEFExtension(&msg.Buffer, Field{"caller", le.lc.String()})
+	}
+	for _, fields := range [][]Field{staticFieldsFor(le), le.fields, msg.meta} {
+		for _, f := range fields {
+			writeCEFExtension(&msg.Buffer, f)
+		}
+	}
+	return nil
+}
+
+// writeCEFHeader appends a CEF header field to buf, escaping pipes and
+// backslashes.
+func writeCEFHeader(buf *bytes.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '|', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\n', '\r':
+			buf.WriteByte(' ')
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
+
+// writeCEFExtension appends a field to buf as a space separated CEF
+// extension, escaping equal signs, backslashes and newlines in the value.
+func writeCEFExtension(buf *bytes.Buffer, f Field) {
+	s := fieldString(f.Val)
+
+	buf.WriteByte(' ')
+	buf.WriteString(f.Key)
+	buf.WriteByte('=')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '=', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
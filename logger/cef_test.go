@@ -0,0 +1,61 @@
+package logger
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_asCEF(t *testing.T) {
+	defer func(name string) { logNameString = name }(logNameString)
+	defer SetCEFHeader("", "", "")
+	logNameString = "chf"
+
+	newMsg := func() *logMessage {
+		return &logMessage{
+			time: time.Unix(1620097321, 123456789),
+			le: logEntry{
+				lvl:    Levels.Warn,
+				pre:    " [pre|x] ",
+				fmt:    "a=b c\\d\nnext\n",
+				lc:     logCaller{File: "a/b.go", Line: 229},
+				fields: []Field{{"shard", 3}},
+			},
+		}
+	}
+
+	msg := newMsg()
+	if err := asCEF(msg); err != nil {
+		t.Fatal(err)
+	}
+	want := `CEF:0|Kentik|chf||Warn|[pre\|x]|5|rt=1620097321123 msg=a\=b c\\d\nnext caller=a/b.go:229 shard=3`
+	if msg.String() != want {
+		t.Errorf("expected %q but got %q", want, msg.String())
+	}
+
+	SetCEFHeader("Acme", "flows", "1.2")
+	msg = newMsg()
+	msg.le.pre = ""
+	if err := asCEF(msg); err != nil {
+		t.Fatal(err)
+	}
+	want = `CEF:0|Acme|flows|1.2|Warn|Warn|5|rt=1620097321123 msg=a\=b c\\d\nnext caller=a/b.go:229 shard=3`
+	if msg.String() != want {
+		t.Errorf("expected %q but got %q", want, msg.String())
+	}
+}
+
+func TestCEFStdString(t *testing.T) {
+	defer func(origCEF bool) { cefFormat = origCEF }(cefFormat)
+	defer func(name string) { logNameString = name }(logNameString)
+	cefFormat, logNameString = true, "svc"
+
+	msg := &logMessage{time: time.Now(), le: logEntry{lvl: Levels.Info, fmt: "started"}}
+	if err := asCEF(msg); err != nil {
+		t.Fatal(err)
+	}
+	msg.WriteByte(0)
+	if line := stdString(msg); !strings.HasPrefix(line, "CEF:0|") {
+		t.Errorf("expected a bare CEF line without the leader but got %q", line)
+	}
+}
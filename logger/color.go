@@ -0,0 +1,111 @@
+package logger
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// colorReset ends an ANSI color sequence.
+const colorReset = "\x1b[0m"
+
+var (
+	// colorized colors the level of messages written to terminals; see
+	// SetColorized. colorForced colors them on any writer.
+	colorized, colorForced bool
+
+	// noColorEnv and forceColorEnv are set from the NO_COLOR, FORCE_COLOR
+	// and CLICOLOR_FORCE environment variables; see setColorEnv
+	noColorEnv, forceColorEnv bool
+
+	// levelColors are the ANSI sequences coloring each level
+	levelColors = map[Level]string{
+		Levels.Panic: "\x1b[35m", // magenta
+		Levels.Error: "\x1b[31m", // red
+		Levels.Warn:  "\x1b[33m", // yellow
+		Levels.Info:  "\x1b[32m", // green
+		Levels.Debug: "\x1b[36m", // cyan
+	}
+
+	// terminals caches whether files are terminals, by *os.File
+	terminals sync.Map
+)
+
+// SetColorized colors the level of string messages written to a terminal,
+// such as stdout in a shell, with ANSI escape sequences. Pipes and files stay
+// plain unless SetColorForced is on. JSON messages are never colored.
+//
+// The environment has the last word: NO_COLOR turns colors off, and
+// otherwise FORCE_COLOR or CLICOLOR_FORCE colors messages on any writer, as
+// SetColorForced does, for instance in CI where output is captured.
+func SetColorized(enabled bool) {
+	colorized = enabled
+}
+
+// SetColorForced colors the level of string messages written to any writer,
+// not just terminals.
+func SetColorForced(forced bool) {
+	colorForced = forced
+}
+
+// SetLevelColor sets the ANSI escape sequence coloring level, for instance
+// "\x1b[1;31m" for bold red. An empty sequence leaves the level plain.
+func SetLevelColor(level Level, ansi string) {
+	colors := make(map[Level]string, len(levelColors)+1)
+	for l, c := range levelColors {
+		colors[l] = c
+	}
+	colors[level] = ansi
+	levelColors = colors
+}
+
+// setColorEnv reads the NO_COLOR, FORCE_COLOR and CLICOLOR_FORCE
+// conventions from the environment. NO_COLOR counts when set to anything
+// but an empty string, and the others when set to anything but empty, "0"
+// or "false".
+func setColorEnv() {
+	noColorEnv = os.Getenv("NO_COLOR") != ""
+	forceColorEnv = envEnabled("FORCE_COLOR") || envEnabled("CLICOLOR_FORCE")
+}
+
+// envEnabled reports whether the environment variable key is set to
+// something other than empty, "0" or "false".
+func envEnabled(key string) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "", "0", "false":
+		return false
+	}
+	return true
+}
+
+// colorize colors the level of line, a message as returned by stdString,
+// when writing it to w calls for it.
+func colorize(w io.Writer, line string, level Level) string {
+	if !colorized || sendJSON || cefFormat || noColorEnv {
+		return line
+	}
+	color := levelColors[level]
+	if color == "" || !colorForced && !forceColorEnv && !isTerminal(w) {
+		return line
+	}
+
+	tok := string(levelFmt(level)) // with the space, so compact levels match as a word
+	return strings.Replace(line, tok, color+strings.TrimSuffix(tok, " ")+colorReset+" ", 1)
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	if term, ok := terminals.Load(f); ok {
+		return term.(bool)
+	}
+
+	info, err := f.Stat()
+	term := err == nil && info.Mode()&os.ModeCharDevice != 0
+	terminals.Store(f, term)
+	return term
+}
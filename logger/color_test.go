@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSetColorized(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetColorized(false)
+	defer SetColorForced(false)
+	defer func(colors map[Level]string) { levelColors = colors }(levelColors)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := New(Levels.Info)
+	SetColorized(true)
+	log.Errorf("", "plain")
+	Drain()
+	if strings.Contains(buf.String(), "\x1b[") {
+		t.Errorf("expected no colors when not writing to a terminal but got %q", buf.String())
+	}
+
+	buf.Reset()
+	SetColorForced(true)
+	SetLevelColor(Levels.Warn, "\x1b[1;33m")
+	log.Errorf("", "red")
+	log.Warnf("", "bold yellow")
+	Drain()
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.Contains(lines[0], "\x1b[31m[Error]\x1b[0m ") {
+		t.Errorf("expected a red level but got %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "\x1b[1;33m[Warn]\x1b[0m ") {
+		t.Errorf("expected a bold yellow level but got %q", lines[1])
+	}
+
+	defer func(origCEF bool) { cefFormat = origCEF }(cefFormat)
+	cefFormat = true
+	line := `CEF:0|Kentik|svc||Error|Error|7|msg=[Error] in the text`
+	if got := colorize(&buf, line, Levels.Error); got != line {
+		t.Errorf("expected CEF lines not to be colorized but got %q", got)
+	}
+}
+
+func Test_isTerminal(t *testing.T) {
+	f, err := os.CreateTemp("", "golog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if isTerminal(f) || isTerminal(f) {
+		t.Error("expected a regular file not to be a terminal, cached or not")
+	}
+	if isTerminal(&bytes.Buffer{}) {
+		t.Error("expected a buffer not to be a terminal")
+	}
+}
+
+func Test_setColorEnv(t *testing.T) {
+	defer setColorEnv()
+	defer SetColorized(false)
+	keys := []string{"NO_COLOR", "FORCE_COLOR", "CLICOLOR_FORCE"}
+	for _, key := range keys {
+		val, ok := os.LookupEnv(key)
+		defer func(key, val string, ok bool) {
+			if ok {
+				os.Setenv(key, val)
+			} else {
+				os.Unsetenv(key)
+			}
+		}(key, val, ok)
+	}
+
+	tests := []struct {
+		noColor, forceColor, clicolorForce string
+		colored                            bool
+	}{
+		{"", "", "", false},
+		{"", "1", "", true},
+		{"", "true", "", true},
+		{"", "0", "", false},
+		{"", "false", "", false},
+		{"", "", "1", true},
+		{"1", "1", "", false},
+		{"1", "", "1", false},
+		{"1", "", "", false},
+	}
+	SetColorized(true)
+	for _, tt := range tests {
+		for i, val := range []string{tt.noColor, tt.forceColor, tt.clicolorForce} {
+			os.Setenv(keys[i], val)
+		}
+		setColorEnv()
+		line := colorize(&bytes.Buffer{}, "[Error] boom", Levels.Error)
+		if colored := strings.Contains(line, "\x1b["); colored != tt.colored {
+			t.Errorf("NO_COLOR=%q FORCE_COLOR=%q CLICOLOR_FORCE=%q: expected colored=%v but got %q",
+				tt.noColor, tt.forceColor, tt.clicolorForce, tt.colored, line)
+		}
+	}
+}
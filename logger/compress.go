@@ -0,0 +1,251 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Compression selects how the default output is compressed; see
+// SetCompression.
+type Compression int
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+)
+
+const (
+	// defaultCompressionInterval is how long compressed output may wait
+	// before it is flushed when SetCompressionInterval is given no interval.
+	defaultCompressionInterval = time.Second
+
+	// maxCompressionPending is how many bytes of framed messages the custom
+	// socket collects before they are compressed and written, whatever the
+	// interval.
+	maxCompressionPending = 64 << 10
+)
+
+var (
+	// compressionInterval bounds how long compressed output is held back
+	compressionInterval = defaultCompressionInterval
+
+	// compressedWriter wraps stdhdl, and compressedSocket collects messages
+	// for the custom socket, when compression is on. Only used by the writer
+	// goroutine once logging starts.
+	compressedWriter *gzipWriter
+	compressedSocket *socketCompressor
+)
+
+// SetCompression gzips the default output, which must be a file or pipe set
+// with SetWriter or SetStdOut, or a stream custom socket. Terminals, syslog
+// and datagram sockets can't carry a compressed stream, and get
+// ErrNotStreamOutput. Level outputs and sinks are not compressed. Call it
+// after selecting the output; CompressionNone turns compression off again.
+// Like ReopenOutput, it runs on the writer goroutine once the messages
+// queued before it are written.
+//
+// Compressed output is flushed every compression interval, see
+// SetCompressionInterval, and by Flush, Drain and Close, so lines aren't
+// held back indefinitely. Each flush ends a deflate block, so the shorter
+// the interval, the sooner lines reach the collector but the worse they
+// compress. Custom socket messages are compressed when flushed, in one
+// gzip stream per connection, which starts over when the socket is
+// reconnected.
+func SetCompression(c Compression) error {
+	errc := make(chan error, 1)
+	err := runOnWriter(context.Background(), func() { errc <- setCompression(c) })
+	if err == ErrLoggerClosed {
+		return setCompression(c) // no writer to race with until Reinit
+	} else if err != nil {
+		return err
+	}
+	return <-errc
+}
+
+// setCompression applies SetCompression; it must run on the writer
+// goroutine, which reads the compressed outputs between messages.
+func setCompression(c Compression) error {
+	if compressedWriter != nil {
+		_ = compressedWriter.close()
+		stdhdl = compressedWriter.w
+		compressedWriter = nil
+	}
+	if compressedSocket != nil {
+		s := compressedSocket
+		compressedSocket = nil
+		_ = s.flush(true)
+	}
+	if c == CompressionNone {
+		return nil
+	}
+
+	switch {
+	case stdhdl != nil:
+		if isTerminal(stdhdl) {
+			return ErrNotStreamOutput
+		}
+		compressedWriter = &gzipWriter{w: stdhdl, gz: gzip.NewWriter(stdhdl)}
+		stdhdl = compressedWriter
+	case customSock != nil && streamNetwork(customSockNetwork):
+		compressedSocket = &socketCompressor{}
+		compressedSocket.gz = gzip.NewWriter(&compressedSocket.out)
+	default:
+		return ErrNotStreamOutput
+	}
+	return nil
+}
+
+// SetCompressionInterval sets how long compressed output may be held back
+// before it is flushed. A d of zero restores the default of one second.
+func SetCompressionInterval(d time.Duration) {
+	if d <= 0 {
+		d = defaultCompressionInterval
+	}
+	compressionInterval = d
+}
+
+// streamNetwork reports whether network carries a byte stream, as opposed
+// to datagrams.
+func streamNetwork(network string) bool {
+	return strings.HasPrefix(network, "tcp") || network == "unix"
+}
+
+// compressionPending reports whether compressed output is waiting to be
+// flushed.
+func compressionPending() bool {
+	return (compressedWriter != nil && compressedWriter.dirty) ||
+		(compressedSocket != nil && len(compressedSocket.pending) > 0)
+}
+
+// flushCompression writes out the compressed output held back; it must run
+// on the writer goroutine.
+func flushCompression() {
+	if compressedWriter != nil {
+		if err := compressedWriter.flush(); err != nil {
+			atomic.AddUint64(&errCount, 1)
+			reportWriteError(err)
+		}
+	}
+	if compressedSocket != nil {
+		reportWriteError(compressedSocket.flush(false))
+	}
+}
+
+// closeCompression ends the compressed streams once the writer goroutine is
+// done, so readers see a complete gzip stream. Logging after Reinit starts
+// new ones.
+func closeCompression() {
+	if compressedWriter != nil {
+		if err := compressedWriter.close(); err != nil {
+			atomic.AddUint64(&errCount, 1)
+			reportWriteError(err)
+		}
+	}
+	if compressedSocket != nil {
+		reportWriteError(compressedSocket.flush(true))
+	}
+}
+
+// gzipWriter compresses what is written to w. Each run of writes between
+// closes is a gzip member of its own, which gzip readers read as one stream.
+type gzipWriter struct {
+	w     io.Writer
+	gz    *gzip.Writer
+	open  bool // a member has been started
+	dirty bool // written to since the last flush
+}
+
+func (c *gzipWriter) Write(p []byte) (int, error) {
+	if !c.open {
+		c.gz.Reset(c.w)
+		c.open = true
+	}
+	c.dirty = true
+	return c.gz.Write(p)
+}
+
+// Reopen ends the gzip member and reopens the underlying writer, if it is a
+// Reopener, so the reopened file starts with a member of its own.
+func (c *gzipWriter) Reopen() error {
+	r, ok := c.w.(Reopener)
+	if !ok {
+		return nil
+	}
+	if err := c.close(); err != nil {
+		return err
+	}
+	return r.Reopen()
+}
+
+func (c *gzipWriter) flush() error {
+	if !c.dirty {
+		return nil
+	}
+	c.dirty = false
+	return c.gz.Flush()
+}
+
+func (c *gzipWriter) close() error {
+	if !c.open {
+		return nil
+	}
+	c.open, c.dirty = false, false
+	return c.gz.Close()
+}
+
+// socketCompressor collects framed messages for the custom socket and
+// writes them out compressed. The messages are kept uncompressed until
+// written, so that if the socket has to be reconnected they can be
+// compressed again at the start of a new stream.
+type socketCompressor struct {
+	gz      *gzip.Writer
+	out     bytes.Buffer
+	pending []byte
+	conn    net.Conn // the connection the gzip stream was started on
+}
+
+// add adds framed messages, writing them out once enough are collected.
+func (c *socketCompressor) add(frames []byte) error {
+	c.pending = append(c.pending, frames...)
+	if len(c.pending) >= maxCompressionPending {
+		return c.flush(false)
+	}
+	return nil
+}
+
+// flush writes the pending messages out. A final flush also ends the gzip
+// stream.
+func (c *socketCompressor) flush(final bool) error {
+	if len(c.pending) == 0 && (!final || c.conn == nil) {
+		return nil
+	}
+	err := writeCustomSocketData(func() []byte { return c.encode(final) })
+	c.pending = c.pending[:0]
+	if final {
+		c.conn = nil
+	}
+	return err
+}
+
+// encode compresses the pending messages for the connected socket, starting
+// a new gzip stream if the socket was reconnected.
+func (c *socketCompressor) encode(final bool) []byte {
+	c.out.Reset()
+	if c.conn != customSock {
+		c.gz.Reset(&c.out)
+		c.conn = customSock
+	}
+	_, _ = c.gz.Write(c.pending) // only fails if out does
+	if final {
+		_ = c.gz.Close()
+	} else {
+		_ = c.gz.Flush()
+	}
+	return c.out.Bytes()
+}
@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gunzip decompresses a flushed but possibly unfinished gzip stream.
+func gunzip(t *testing.T, b []byte) string {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestSetCompression(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+	if err := SetCompression(CompressionGzip); err != nil {
+		t.Fatal(err)
+	}
+	defer SetCompression(CompressionNone)
+
+	log := New(Levels.Info)
+	log.Infof("", "first")
+	log.Infof("", "second")
+	Drain()
+
+	lines := gunzip(t, buf.Bytes())
+	if !strings.Contains(lines, "first\n") || !strings.HasSuffix(lines, "second\n") {
+		t.Errorf("expected both messages once drained but got %q", lines)
+	}
+
+	if err := SetCompression(CompressionNone); err != nil || stdhdl != &buf {
+		t.Errorf("expected turning compression off to restore the output but got %v", err)
+	}
+}
+
+func TestSetCompressionSocket(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer func(sock net.Conn, network string) {
+		customSock, customSockNetwork = sock, network
+		atomic.StoreInt32(&customSockConnected, 0)
+	}(customSock, customSockNetwork)
+	defer SetCompressionInterval(0)
+	defer SetSocketFormat(SocketFormatSyslog)
+
+	conn := &recordingConn{}
+	stdhdl, customSock, customSockNetwork = nil, conn, "tcp"
+	atomic.StoreInt32(&customSockConnected, 1)
+	SetSocketFormat(SocketFormatRaw)
+	SetCompressionInterval(10 * time.Millisecond)
+	if err := SetCompression(CompressionGzip); err != nil {
+		t.Fatal(err)
+	}
+	defer SetCompression(CompressionNone)
+
+	log := New(Levels.Info)
+	log.Infof("", "first")
+	log.Infof("", "second")
+	deadline := time.Now().Add(time.Second)
+	for len(conn.Writes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if len(conn.Writes()) == 0 {
+		t.Fatal("expected compressed output to be written after the interval")
+	}
+	Drain()
+	if lines := gunzip(t, bytes.Join(conn.Writes(), nil)); !strings.Contains(lines, "> first\n") || !strings.HasSuffix(lines, "> second\n") {
+		t.Errorf("expected both messages in the stream but got %q", lines)
+	}
+
+	customSockNetwork = "udp"
+	if err := SetCompression(CompressionGzip); err != ErrNotStreamOutput {
+		t.Errorf("expected a datagram socket to be refused but got %v", err)
+	}
+	customSock = nil
+	if err := SetCompression(CompressionGzip); err != ErrNotStreamOutput {
+		t.Errorf("expected syslog to be refused but got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package logger
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options describes the logger configuration of a program, as read from its
+// flags or config file.
+type Options struct {
+	LogName string
+	Level   string // a level name accepted by ParseLevel
+	Format  string // "text" (or empty), or a KENTIK_LOG_FMT format such as "json"
+	Output  string // "syslog" (or empty), "stdout", "stderr", "socket" or "file"
+
+	// Network and Address of the custom socket, for the "socket" output,
+	// dialed over TLS if set, verifying the server against the system
+	// roots; see SetCustomSocketTLS
+	Network string
+	Address string
+	TLS     bool
+
+	// BatchSize and BatchInterval batch the messages of the "socket"
+	// output; see SetSocketBatching
+	BatchSize     int
+	BatchInterval time.Duration
+
+	// File is the path of the RotatingFileWriter of the "file" output, with
+	// its size limit, number of backups and whether they are compressed;
+	// see NewRotatingFileWriter
+	File            string
+	MaxBytes        int64
+	Backups         int
+	CompressBackups bool
+
+	// SampleRates keeps only every n-th message at the level named by the
+	// key; see SetSampleRate
+	SampleRates map[string]uint64
+
+	PoolSize int // see Configure; zero means NumMessages
+}
+
+// ConfigError lists every problem found by ValidateConfig.
+type ConfigError []error
+
+func (ce ConfigError) Error() string {
+	msgs := make([]string, len(ce))
+	for i, err := range ce {
+		msgs[i] = err.Error()
+	}
+	return "invalid log config: " + strings.Join(msgs, "; ")
+}
+
+// ValidateConfig checks opts without applying them: no socket is dialed, no
+// file is opened and no global logger state is changed. It lets programs
+// fail fast at startup on a bad configuration. All the problems found are
+// returned together as a ConfigError. NewFromConfig makes the same checks.
+func ValidateConfig(opts Options) error {
+	var errs ConfigError
+
+	if _, err := ParseLevel(opts.Level); err != nil {
+		errs = append(errs, fmt.Errorf("unsupported level %q", opts.Level))
+	}
+
+	format := strings.ToLower(opts.Format)
+	if _, ok := messageFormats[format]; !ok && format != "" && format != "text" {
+		errs = append(errs, fmt.Errorf("unsupported format %q", opts.Format))
+	}
+
+	output := strings.ToLower(opts.Output)
+	switch output {
+	case "", "syslog", "stdout", "stderr", "socket", "file":
+	default:
+		errs = append(errs, fmt.Errorf("unsupported output %q", opts.Output))
+	}
+	if output == "socket" {
+		if err := validateAddress(opts.Network, opts.Address); err != nil {
+			errs = append(errs, err)
+		}
+		if opts.TLS && !streamNetwork(opts.Network) {
+			errs = append(errs, fmt.Errorf("TLS over %q socket", opts.Network))
+		}
+		if opts.BatchSize < 0 || opts.BatchInterval < 0 {
+			errs = append(errs, fmt.Errorf("negative socket batching %d, %v", opts.BatchSize, opts.BatchInterval))
+		}
+	} else if opts.Address != "" || opts.Network != "" || opts.TLS {
+		errs = append(errs, fmt.Errorf("socket address set for %q output", opts.Output))
+	} else if opts.BatchSize != 0 || opts.BatchInterval != 0 {
+		errs = append(errs, fmt.Errorf("socket batching set for %q output", opts.Output))
+	}
+	if output == "file" {
+		errs = append(errs, validateRotation(opts)...)
+	} else if opts.File != "" || opts.MaxBytes != 0 || opts.Backups != 0 || opts.CompressBackups {
+		errs = append(errs, fmt.Errorf("log file set for %q output", opts.Output))
+	}
+
+	for name := range opts.SampleRates {
+		if level, err := ParseLevel(name); err != nil || level == Levels.Off {
+			errs = append(errs, fmt.Errorf("unsupported sampling level %q", name))
+		}
+	}
+
+	if opts.PoolSize < 0 {
+		errs = append(errs, fmt.Errorf("negative pool size %d", opts.PoolSize))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// NewFromConfig applies opts, once ValidateConfig finds no problem with
+// them, and returns a logger at their level and sample rates. It sets the
+// global output, format, log name and pool size, so call it once at
+// startup. If the file or socket can't be opened, the error is returned and
+// the global state is left as it was.
+func NewFromConfig(opts Options) (*Logger, error) {
+	if err := ValidateConfig(opts); err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(opts.Output) {
+	case "stdout":
+		SetOutputMode(ModeStdout)
+		SetStdOut()
+	case "stderr":
+		SetOutputMode(ModeStdout)
+		SetStdErr()
+	case "file":
+		w, err := NewRotatingFileWriter(opts.File, opts.MaxBytes, opts.Backups, opts.CompressBackups)
+		if err != nil {
+			return nil, err
+		}
+		SetOutputMode(ModeStdout)
+		SetWriter(w)
+	case "socket":
+		var err error
+		if opts.TLS {
+			err = SetCustomSocketTLS(opts.Address, opts.Network, nil)
+		} else {
+			err = SetCustomSocket(opts.Address, opts.Network)
+		}
+		if err != nil {
+			return nil, err
+		}
+		SetOutputMode(ModeSocket)
+		SetWriter(nil)
+		SetSocketBatching(opts.BatchSize, opts.BatchInterval)
+	default:
+		SetOutputMode(ModeSyslog)
+		SetWriter(nil)
+	}
+	if err := SetLogName(opts.LogName); err != nil {
+		return nil, err
+	}
+	setFormatName(opts.Format)
+	if opts.PoolSize > 0 {
+		Configure(opts.PoolSize)
+	}
+
+	level, _ := ParseLevel(opts.Level)
+	l := New(level)
+	for name, n := range opts.SampleRates {
+		level, _ := ParseLevel(name)
+		l.SetSampleRate(level, n)
+	}
+	return l, nil
+}
+
+// validateRotation checks the log file settings of the "file" output,
+// without opening the file.
+func validateRotation(opts Options) (errs []error) {
+	if opts.File == "" {
+		errs = append(errs, fmt.Errorf("empty log file path"))
+	} else if info, err := os.Stat(filepath.Dir(opts.File)); err != nil || !info.IsDir() {
+		errs = append(errs, fmt.Errorf("no directory for log file %q", opts.File))
+	}
+	if opts.MaxBytes <= 0 {
+		errs = append(errs, fmt.Errorf("invalid max size %d for log file", opts.MaxBytes))
+	}
+	if opts.Backups < 0 {
+		errs = append(errs, fmt.Errorf("negative log file backups %d", opts.Backups))
+	}
+	return errs
+}
+
+// validateAddress checks that address can be dialed on network, without
+// dialing it.
+func validateAddress(network, address string) error {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("bad socket address %q: %v", address, err)
+		}
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			return fmt.Errorf("bad socket port in %q", address)
+		}
+	case "unix", "unixgram":
+		if address == "" {
+			return fmt.Errorf("empty %s socket path", network)
+		}
+	default:
+		return fmt.Errorf("unsupported socket network %q", network)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		opts Options
+		errs []string
+	}{
+		{Options{Level: "info"}, nil},
+		{Options{Level: "Debug", Format: "json", Output: "stdout"}, nil},
+		{Options{Level: "warn", Output: "socket", Network: "udp", Address: "127.0.0.1:514"}, nil},
+		{Options{Level: "warn", Output: "socket", Network: "unix", Address: "/dev/log"}, nil},
+		{Options{Level: "warning", Format: "gelf"}, nil},
+		{Options{Level: "err", Format: "CEF"}, nil},
+		{Options{Level: "info", Format: "ecs", SampleRates: map[string]uint64{"debug": 10, "access": 100}}, nil},
+		{Options{Level: "info", Output: "file", File: filepath.Join(os.TempDir(), "golog.log"), MaxBytes: 1 << 20, Backups: 3, CompressBackups: true}, nil},
+		{Options{Level: "chatty"}, []string{`unsupported level "chatty"`}},
+		{Options{Level: "info", Format: "xml"}, []string{`unsupported format "xml"`}},
+		{Options{Level: "info", SampleRates: map[string]uint64{"off": 2}}, []string{`unsupported sampling level "off"`}},
+		{Options{Level: "info", SampleRates: map[string]uint64{"verbose": 2}}, []string{`unsupported sampling level "verbose"`}},
+		{
+			Options{Level: "info", Output: "file", File: "/no/such/dir/golog.log", Backups: -1},
+			[]string{"no directory for log file", "invalid max size 0", "negative log file backups"},
+		},
+		{Options{Level: "info", Output: "file", MaxBytes: 1024}, []string{"empty log file path"}},
+		{Options{Level: "info", Output: "stdout", File: "golog.log"}, []string{"log file set"}},
+		{Options{Level: "info", Output: "socket", Network: "tcp", Address: "collector"}, []string{"bad socket address"}},
+		{Options{Level: "info", Output: "socket", Network: "tcp", Address: "collector:syslog"}, []string{"bad socket port"}},
+		{Options{Level: "info", Output: "socket", Network: "sctp", Address: "collector:514"}, []string{"unsupported socket network"}},
+		{Options{Level: "info", Output: "stdout", Address: "collector:514"}, []string{"socket address set"}},
+		{Options{Level: "info", Output: "socket", Network: "tcp", Address: "collector:6514", TLS: true, BatchSize: 64, BatchInterval: time.Second}, nil},
+		{Options{Level: "info", Output: "socket", Network: "udp", Address: "collector:514", TLS: true}, []string{`TLS over "udp" socket`}},
+		{Options{Level: "info", Output: "socket", Network: "tcp", Address: "collector:514", BatchSize: -1}, []string{"negative socket batching"}},
+		{Options{Level: "info", Output: "stdout", TLS: true}, []string{"socket address set"}},
+		{Options{Level: "info", Output: "file", File: "golog.log", MaxBytes: 1024, BatchSize: 64}, []string{"socket batching set"}},
+		{
+			Options{Level: "loud", Format: "yaml", Output: "tape", PoolSize: -1},
+			[]string{"unsupported level", "unsupported format", "unsupported output", "negative pool size"},
+		},
+	}
+
+	for _, tt := range tests {
+		err := ValidateConfig(tt.opts)
+		if len(tt.errs) == 0 {
+			if err != nil {
+				t.Errorf("%+v: expected no error but got %v", tt.opts, err)
+			}
+			continue
+		}
+
+		ce, ok := err.(ConfigError)
+		if !ok || len(ce) != len(tt.errs) {
+			t.Errorf("%+v: expected %d errors but got %v", tt.opts, len(tt.errs), err)
+			continue
+		}
+		for i, want := range tt.errs {
+			if !strings.Contains(ce[i].Error(), want) {
+				t.Errorf("%+v: expected error %q but got %q", tt.opts, want, ce[i])
+			}
+		}
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer func(mode OutputMode, name string) { outputMode, logNameString = mode, name }(outputMode, logNameString)
+	defer setFormat()
+	dir, err := os.MkdirTemp("", "golog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	log, err := NewFromConfig(Options{
+		LogName:     "app",
+		Level:       "warn",
+		Format:      "json",
+		Output:      "file",
+		File:        path,
+		MaxBytes:    1 << 20,
+		SampleRates: map[string]uint64{"warn": 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdhdl.(*RotatingFileWriter).Close()
+
+	for i := 0; i < 4; i++ {
+		log.Warnf("", "warn %d", i)
+	}
+	log.Infof("", "info")
+	if err := Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "{") || strings.Contains(string(b), "info") {
+		t.Errorf("expected 2 sampled json warnings but got %q", b)
+	}
+
+	stdhdl = nil
+	_, err = NewFromConfig(Options{Level: "info", Output: "file", File: filepath.Join(dir, "none", "app.log"), MaxBytes: 1024})
+	if _, ok := err.(ConfigError); !ok || stdhdl != nil {
+		t.Errorf("expected a ConfigError leaving the output unset but got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"context"
+	"sort"
+)
+
+// contextExtractor returns the fields to log for a context; see
+// SetContextExtractor
+var contextExtractor func(ctx context.Context) map[string]string
+
+// SetContextExtractor sets the function the *Context logging methods use to
+// get fields from their context, such as OpenTelemetry trace and span IDs.
+// It should be called before logging starts.
+func SetContextExtractor(fn func(ctx context.Context) map[string]string) {
+	contextExtractor = fn
+}
+
+// contextFields returns the fields extracted from ctx, sorted by key.
+func contextFields(ctx context.Context) []Field {
+	if contextExtractor == nil || ctx == nil {
+		return nil
+	}
+	values := contextExtractor(ctx)
+	if len(values) == 0 {
+		return nil
+	}
+
+	fields := make([]Field, 0, len(values))
+	for k, v := range values {
+		fields = append(fields, Field{k, v})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+	return fields
+}
+
+// DebugfContext logs a printf-style debug message with the fields extracted
+// from ctx
+func (l *Logger) DebugfContext(ctx context.Context, prefix, format string, v ...interface{}) {
+	if l.levelEnabled(Levels.Debug) {
+		l.log(Levels.Debug, prefix, format, v, true, contextFields(ctx))
+	}
+}
+
+// InfofContext logs a printf-style info message with the fields extracted
+// from ctx
+func (l *Logger) InfofContext(ctx context.Context, prefix, format string, v ...interface{}) {
+	if l.levelEnabled(Levels.Info) {
+		l.log(Levels.Info, prefix, format, v, true, contextFields(ctx))
+	}
+}
+
+// WarnfContext logs a printf-style warn message with the fields extracted
+// from ctx
+func (l *Logger) WarnfContext(ctx context.Context, prefix, format string, v ...interface{}) {
+	if l.levelEnabled(Levels.Warn) {
+		l.log(Levels.Warn, prefix, format, v, true, contextFields(ctx))
+	}
+}
+
+// ErrorfContext logs a printf-style error message with the fields extracted
+// from ctx
+func (l *Logger) ErrorfContext(ctx context.Context, prefix, format string, v ...interface{}) {
+	if l.levelEnabled(Levels.Error) {
+		l.log(Levels.Error, prefix, format, v, true, contextFields(ctx))
+	}
+}
+
+// PanicfContext logs a printf-style panic message with the fields extracted
+// from ctx
+func (l *Logger) PanicfContext(ctx context.Context, prefix, format string, v ...interface{}) {
+	if l.levelEnabled(Levels.Panic) {
+		l.log(Levels.Panic, prefix, format, v, true, contextFields(ctx))
+	}
+}
@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+type traceKey struct{}
+
+func TestContextLogging(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetContextExtractor(nil)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	SetContextExtractor(func(ctx context.Context) map[string]string {
+		id, ok := ctx.Value(traceKey{}).(string)
+		if !ok {
+			return nil
+		}
+		return map[string]string{"trace_id": id, "span_id": "s1"}
+	})
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "t1")
+	log := New(Levels.Info).WithFields(Field{"service", "chf"})
+	log.InfofContext(ctx, "[ctx] ", "handled")
+	log.ErrorfContext(context.Background(), "[ctx] ", "no trace")
+	log.DebugfContext(ctx, "[ctx] ", "filtered out")
+	Drain()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines but got %q", buf.String())
+	}
+	if !strings.HasSuffix(lines[0], "handled service=chf span_id=s1 trace_id=t1") {
+		t.Errorf("expected the context fields after the logger fields but got %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "no trace service=chf") {
+		t.Errorf("expected only the logger fields but got %q", lines[1])
+	}
+}
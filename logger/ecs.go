@@ -0,0 +1,67 @@
+package logger
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ecsVersion is the version of the Elastic Common Schema messages follow.
+const ecsVersion = "1.6.0"
+
+// ecsEntry is the shape of a message when logging in the Elastic Common
+// Schema. Fields are added at the root under their own keys.
+type ecsEntry struct {
+	Timestamp jsonTime `json:"@timestamp"`
+	Log       ecsLog   `json:"log"`
+	Message   string   `json:"message"`
+	Service   *ecsName `json:"service,omitempty"`
+	ECS       ecsName  `json:"ecs"`
+}
+
+type ecsLog struct {
+	Level  string     `json:"level"`
+	Logger string     `json:"logger,omitempty"`
+	Origin *ecsOrigin `json:"origin,omitempty"`
+}
+
+type ecsOrigin struct {
+	File struct {
+		Name string `json:"name"`
+		Line int    `json:"line"`
+	} `json:"file"`
+}
+
+// ecsName is an object with a single field, such as service.name or
+// ecs.version.
+type ecsName struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// asECS renders the message as an Elastic Common Schema object followed by
+// a newline: the prefix is log.logger, the caller log.origin and the log
+// name service.name.
+func asECS(msg *logMessage) error {
+	le := &msg.le
+	entry := ecsEntry{
+		Timestamp: jsonTime(msg.time),
+		Log: ecsLog{
+			Level:  strings.ToLower(le.lvl.String()),
+			Logger: strings.TrimSpace(le.pre),
+		},
+		Message: jsonMessage(le),
+		ECS:     ecsName{Version: ecsVersion},
+	}
+	if le.lc.File != "" {
+		entry.Log.Origin = &ecsOrigin{}
+		entry.Log.Origin.File.Name, entry.Log.Origin.File.Line = le.lc.File, le.lc.Line
+	}
+	if logNameString != "" {
+		entry.Service = &ecsName{Name: logNameString}
+	}
+	if err := json.NewEncoder(msg).Encode(&entry); err != nil {
+		return err
+	}
+
+	return writeFieldsJSON(&msg.Buffer, staticFieldsFor(le), le.fields, promotedFields(le, entry.Message), msg.meta)
+}
@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_asECS(t *testing.T) {
+	defer func(name string) { logNameString = name }(logNameString)
+	logNameString = "chf"
+
+	msg := &logMessage{
+		time: time.Date(2021, 5, 4, 3, 2, 1, 123000000, time.UTC),
+		le: logEntry{
+			lvl:    Levels.Warn,
+			pre:    " [pre] ",
+			fmt:    "hello %s\n",
+			fmtV:   []interface{}{"world"},
+			lc:     logCaller{File: "a/b.go", Line: 229},
+			fields: []Field{{"shard", 3}},
+		},
+	}
+	if err := asECS(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(msg.Bytes(), &got); err != nil {
+		t.Fatalf("expected a JSON object but got %q: %v", msg.String(), err)
+	}
+	want := map[string]interface{}{
+		"@timestamp": "2021-05-04T03:02:01.123Z",
+		"log": map[string]interface{}{
+			"level":  "warn",
+			"logger": "[pre]",
+			"origin": map[string]interface{}{
+				"file": map[string]interface{}{"name": "a/b.go", "line": 229.0},
+			},
+		},
+		"message": "hello world",
+		"service": map[string]interface{}{"name": "chf"},
+		"ecs":     map[string]interface{}{"version": ecsVersion},
+		"shard":   3.0,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v but got %v", want, got)
+	}
+}
@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+const (
+	// fatalFlushTimeout bounds how long Fatalf waits for pending messages to
+	// be written before exiting.
+	fatalFlushTimeout = 5 * time.Second
+
+	// panicFlushTimeout bounds how long Panicf waits for pending messages to
+	// be written before panicking.
+	panicFlushTimeout = time.Second
+)
+
+var (
+	// exit is os.Exit, replaced in tests
+	exit = os.Exit
+
+	// fatalExitCode is the exit code used by Fatalf; see SetFatalExitCode
+	fatalExitCode = 1
+)
+
+// ExitCoder is implemented by errors that know the exit code a program
+// should return when failing because of them.
+type ExitCoder interface {
+	ExitCode() int
+}
+
+// SetFatalExitCode sets the exit code Fatalf uses when none of its arguments
+// is an ExitCoder error. It is 1 by default.
+func SetFatalExitCode(code int) {
+	fatalExitCode = code
+}
+
+// Fatalf logs a printf-style message at the Panic level, waits for pending
+// messages to be written, and exits the program. The exit code comes from
+// the first argument that is an error implementing ExitCoder (looking
+// through wrapped errors), and otherwise is the one set with
+// SetFatalExitCode. Unlike the other methods, it exits even on a nil logger.
+func (l *Logger) Fatalf(prefix, format string, v ...interface{}) {
+	l.log(Levels.Panic, prefix, format, v, true, nil)
+	DrainWithTimeout(fatalFlushTimeout)
+	exit(exitCodeFor(v))
+}
+
+// Panicf logs a printf-style panic message, waits briefly for pending
+// messages to be written, and panics with the formatted message. Like the
+// other methods, and unlike Fatalf, it does nothing on a nil logger or one
+// with level Off such as OffLogger, so it doesn't panic either.
+func (l *Logger) Panicf(prefix, format string, v ...interface{}) {
+	if !l.levelEnabled(Levels.Panic) {
+		return
+	}
+	l.log(Levels.Panic, prefix, format, v, true, nil)
+	DrainWithTimeout(panicFlushTimeout)
+	panic(fmt.Sprintf(format, v...))
+}
+
+// exitCodeFor returns the exit code for a fatal message with arguments v.
+func exitCodeFor(v []interface{}) int {
+	for _, arg := range v {
+		err, ok := arg.(error)
+		if !ok {
+			continue
+		}
+		var ec ExitCoder
+		if errors.As(err, &ec) {
+			return ec.ExitCode()
+		}
+	}
+	return fatalExitCode
+}
+
+// RecoverAndFlush logs a panic at the Panic level, with the stack of the
+// panicking goroutine in a stack field, waits briefly for pending messages
+// to be written, and panics again with the same value. Without it a panic
+// ends the program before the writer goroutine writes the queued messages,
+// which are often the ones explaining the crash. Defer it first thing at
+// the entry point of main and of each goroutine, as it only sees panics of
+// its own goroutine:
+//
+//	go func() {
+//		defer logger.RecoverAndFlush()
+//		...
+//	}()
+//
+// It does nothing when there is no panic.
+func RecoverAndFlush() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	_ = New(Levels.Panic).logAt(logCaller{}, Levels.Panic, "[panic] ", "%v", []interface{}{r}, true, []Field{{"stack", string(debug.Stack())}})
+	DrainWithTimeout(panicFlushTimeout)
+	panic(r)
+}
@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+type exitError int
+
+func (e exitError) Error() string { return fmt.Sprintf("exit %d", int(e)) }
+func (e exitError) ExitCode() int { return int(e) }
+
+func TestFatalf(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer func(origExit func(int)) { exit = origExit }(exit)
+	defer SetFatalExitCode(1)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	var code int
+	exited := 0
+	exit = func(c int) { code = c; exited++ }
+
+	log := New(Levels.Info)
+	log.Fatalf("[main] ", "cannot start: %v", errors.New("boom"))
+	if exited != 1 || code != 1 {
+		t.Errorf("expected exit code 1 but got %d (exited %d times)", code, exited)
+	}
+	if !strings.Contains(buf.String(), "[Panic] [main] ") || !strings.HasSuffix(buf.String(), "cannot start: boom\n") {
+		t.Errorf("expected the fatal message to be flushed before exiting but got %q", buf.String())
+	}
+
+	SetFatalExitCode(3)
+	log.Fatalf("[main] ", "bad config")
+	if code != 3 {
+		t.Errorf("expected the configured exit code 3 but got %d", code)
+	}
+
+	log.Fatalf("[main] ", "%s: %v", "wrapped", fmt.Errorf("setup: %w", exitError(78)))
+	if code != 78 {
+		t.Errorf("expected the ExitCoder exit code 78 but got %d", code)
+	}
+
+	var nilLog *Logger
+	nilLog.Fatalf("", "nil logger")
+	if exited != 4 {
+		t.Error("expected a nil logger to exit too")
+	}
+}
+
+func TestPanicf(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	panicked := func(log *Logger) (v interface{}) {
+		defer func() { v = recover() }()
+		log.Panicf("[main] ", "cannot start: %v", errors.New("boom"))
+		return nil
+	}
+
+	if v := panicked(New(Levels.Info)); v != "cannot start: boom" {
+		t.Errorf("expected a panic with the formatted message but got %v", v)
+	}
+	if !strings.Contains(buf.String(), "[Panic] [main] ") || !strings.HasSuffix(buf.String(), "cannot start: boom\n") {
+		t.Errorf("expected the message to be flushed before panicking but got %q", buf.String())
+	}
+
+	if v := panicked(nil); v != nil {
+		t.Errorf("expected a nil logger not to panic but got %v", v)
+	}
+	if v := panicked(OffLogger); v != nil {
+		t.Errorf("expected OffLogger not to panic but got %v", v)
+	}
+}
+
+func TestRecoverAndFlush(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	var logged string
+	v := func() (v interface{}) {
+		defer func() {
+			v = recover()
+			logged = buf.String() // without draining
+		}()
+		defer RecoverAndFlush()
+		New(Levels.Info).Infof("[worker] ", "before the crash")
+		panic("boom")
+	}()
+
+	if v != "boom" {
+		t.Errorf("expected the panic to carry on with its value but got %v", v)
+	}
+	if !strings.Contains(logged, "before the crash\n") || !strings.Contains(logged, "[Panic] [panic] boom stack=") || !strings.Contains(logged, "TestRecoverAndFlush") {
+		t.Errorf("expected the pending messages and the panic with its stack before the panic went on but got %q", logged)
+	}
+
+	func() {
+		defer RecoverAndFlush() // must not panic without a panic
+	}()
+}
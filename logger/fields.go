@@ -0,0 +1,506 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Field is a key/value pair attached to log messages. In string output it
+// is rendered as key=value after the message, and in JSON output as an
+// additional top-level key.
+type Field struct {
+	Key string
+	Val interface{}
+}
+
+// staticFields holds the []Field added to every message, sorted by key.
+// It is replaced as a whole, never modified, so messages read it without
+// locking.
+var staticFields atomic.Value
+
+// SetGlobalFields sets fields added to every message logged by the process,
+// such as service, version or datacenter, replacing any previously set
+// global fields. They come first, sorted by key. A field of a logger or of a
+// logging call with the same key takes precedence, and the global field is
+// left out of that message.
+func SetGlobalFields(fields map[string]interface{}) {
+	fs := make([]Field, 0, len(fields))
+	for k, v := range fields {
+		fs = append(fs, Field{k, v})
+	}
+	sort.Slice(fs, func(i, j int) bool { return fs[i].Key < fs[j].Key })
+	staticFields.Store(fs)
+}
+
+// SetStaticFields is SetGlobalFields, under its older name.
+func SetStaticFields(fields map[string]interface{}) {
+	SetGlobalFields(fields)
+}
+
+// getStaticFields returns the current static fields. The result must not be
+// modified.
+func getStaticFields() []Field {
+	fs, _ := staticFields.Load().([]Field)
+	return fs
+}
+
+// staticFieldsFor returns the static fields of a message, without those
+// overridden by the fields of the entry. It only allocates when some are.
+func staticFieldsFor(le *logEntry) []Field {
+	static := getStaticFields()
+	if len(le.fields) == 0 {
+		return static
+	}
+	for i, f := range static {
+		if hasField(le.fields, f.Key) {
+			fs := append(make([]Field, 0, len(static)-1), static[:i]...)
+			for _, f := range static[i+1:] {
+				if !hasField(le.fields, f.Key) {
+					fs = append(fs, f)
+				}
+			}
+			return fs
+		}
+	}
+	return static
+}
+
+// SetDeployment adds env and region static fields to every message. Empty
+// arguments are read from the DEPLOY_ENV and DEPLOY_REGION environment
+// variables, and fields that are still empty are left out. It is merged into
+// the fields set with SetStaticFields, so call it after SetStaticFields.
+func SetDeployment(env, region string) {
+	if env == "" {
+		env = os.Getenv("DEPLOY_ENV")
+	}
+	if region == "" {
+		region = os.Getenv("DEPLOY_REGION")
+	}
+
+	fields := map[string]interface{}{}
+	for _, f := range getStaticFields() {
+		fields[f.Key] = f.Val
+	}
+	if env != "" {
+		fields["env"] = env
+	}
+	if region != "" {
+		fields["region"] = region
+	}
+	SetStaticFields(fields)
+}
+
+// ErrorCoder is implemented by errors that carry a machine readable code,
+// logged by ErrField as error.code.
+type ErrorCoder interface {
+	Code() string
+}
+
+// ErrorCategorizer is implemented by errors that belong to a category,
+// logged by ErrField as error.category.
+type ErrorCategorizer interface {
+	Category() string
+}
+
+// ErrField returns the fields describing err: error.message, plus
+// error.code and error.category when err, or an error it wraps, implements
+// ErrorCoder or ErrorCategorizer. It returns nil for a nil error.
+func ErrField(err error) []Field {
+	if err == nil {
+		return nil
+	}
+
+	fields := []Field{{"error.message", err.Error()}}
+	var coder ErrorCoder
+	if errors.As(err, &coder) {
+		fields = append(fields, Field{"error.code", coder.Code()})
+	}
+	var categorizer ErrorCategorizer
+	if errors.As(err, &categorizer) {
+		fields = append(fields, Field{"error.category", categorizer.Category()})
+	}
+	return fields
+}
+
+// errorStacks adds a stack trace to messages logged with Errorw
+var errorStacks bool
+
+// SetErrorStacks makes Errorw capture the stack of the caller in a stack
+// field. It is off by default since capturing the stack is expensive.
+func SetErrorStacks(enabled bool) {
+	errorStacks = enabled
+}
+
+// Errorw logs msg at the error level with err in an error field, followed by
+// fields. In JSON output it also adds the messages of the errors err wraps,
+// from errors.Unwrap, in an error_chain array, and the stack when
+// SetErrorStacks is on.
+func (l *Logger) Errorw(prefix, msg string, err error, fields ...Field) {
+	if !l.levelEnabled(Levels.Error) {
+		return
+	}
+
+	ef := make([]Field, 0, len(fields)+3)
+	if err != nil {
+		ef = append(ef, Field{"error", err.Error()})
+		if sendJSON {
+			if chain := errorChain(err); len(chain) > 1 {
+				ef = append(ef, Slice("error_chain", chain))
+			}
+			if errorStacks {
+				ef = append(ef, Field{"stack", callerStack()})
+			}
+		}
+	}
+	l.log(Levels.Error, prefix, "%s", []interface{}{msg}, true, append(ef, fields...))
+}
+
+// errorChain returns the messages of err and of the errors it wraps.
+func errorChain(err error) []string {
+	var chain []string
+	for ; err != nil; err = errors.Unwrap(err) {
+		chain = append(chain, err.Error())
+	}
+	return chain
+}
+
+// callerStack returns the stack of the caller of the logging method, one
+// "function file:line" frame per line.
+func callerStack() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs) // skip Callers, callerStack and Errorw
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var b strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s %s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// sliceMaxLen is the number of elements logged by Slice; see SetSliceMaxLen
+var sliceMaxLen = 100
+
+// SetSliceMaxLen sets how many elements of a slice Slice logs; the rest are
+// replaced by a marker saying how many were left out. It is 100 by default.
+func SetSliceMaxLen(n int) {
+	sliceMaxLen = n
+}
+
+// sliceValue is a slice logged by Slice, with at most sliceMaxLen elements.
+type sliceValue struct {
+	elems   []interface{}
+	omitted int
+}
+
+// Slice returns a field logging the slice or array v as a JSON array in JSON
+// output, and as [a b c] in string output. Only the first elements are
+// logged, see SetSliceMaxLen. Values that are not slices or arrays are
+// logged as they are.
+func Slice(key string, v interface{}) Field {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return Field{key, v}
+	}
+
+	n := rv.Len()
+	if n > sliceMaxLen {
+		n = sliceMaxLen
+	}
+	sv := sliceValue{elems: make([]interface{}, n), omitted: rv.Len() - n}
+	for i := range sv.elems {
+		sv.elems[i] = rv.Index(i).Interface()
+	}
+	return Field{key, sv}
+}
+
+// omittedMarker is the last element of a truncated slice.
+func (sv sliceValue) omittedMarker() string {
+	return fmt.Sprintf("...(%d more)", sv.omitted)
+}
+
+func (sv sliceValue) String() string {
+	if sv.omitted > 0 {
+		return fmt.Sprint(append(sv.elems[:len(sv.elems):len(sv.elems)], sv.omittedMarker()))
+	}
+	return fmt.Sprint(sv.elems)
+}
+
+func (sv sliceValue) MarshalJSON() ([]byte, error) {
+	if sv.omitted > 0 {
+		return json.Marshal(append(sv.elems[:len(sv.elems):len(sv.elems)], sv.omittedMarker()))
+	}
+	if sv.elems == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(sv.elems)
+}
+
+// WithFields returns a copy of the logger that adds fields to every message
+// it logs, after any static fields. They replace the fields of the logger
+// with the same keys.
+func (l *Logger) WithFields(fields ...Field) *Logger {
+	if l == nil {
+		return nil
+	}
+
+	child := l.clone()
+	child.fields = mergeFields(l.fields, fields)
+	return child
+}
+
+// WithError returns a copy of the logger that adds err to every message it
+// logs, as an error field, like Errorw, so that
+//
+//	log.WithError(err).Errorf(prefix, "failed to connect")
+//
+// logs error="..." in text, and an "error" key in JSON along with the
+// error_chain of the errors err wraps. The messages are captured when it is
+// called, so later changes to err don't show. A nil err adds nothing, and
+// the logger itself is returned.
+func (l *Logger) WithError(err error) *Logger {
+	if l == nil || err == nil {
+		return l
+	}
+
+	fields := []Field{{"error", err.Error()}}
+	if sendJSON {
+		if chain := errorChain(err); len(chain) > 1 {
+			fields = append(fields, Slice("error_chain", chain))
+		}
+	}
+	return l.WithFields(fields...)
+}
+
+// requestIDEncoding encodes request IDs in lower case base32, without
+// padding, which is short and safe to echo in headers and URLs.
+var requestIDEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// WithRequestID returns a copy of the logger with a request_id field set to
+// a new random ID, and the ID, so that all the messages of a request can be
+// correlated when there is no trace ID to use, and handlers can echo it to
+// clients. IDs are 16 characters of base32 from 80 random bits, unique
+// enough for the requests of a service.
+func (l *Logger) WithRequestID() (*Logger, string) {
+	id := newRequestID()
+	return l.WithFields(Field{"request_id", id}), id
+}
+
+// newRequestID returns a random request ID, falling back on the clock if the
+// system has no randomness to spare.
+func newRequestID() string {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		binary.BigEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+	}
+	return requestIDEncoding.EncodeToString(b)
+}
+
+// mergeFields returns the fields of a logger followed by the fields of a
+// call or derived logger, leaving out the fields of the logger they
+// override.
+func mergeFields(logger, call []Field) []Field {
+	fs := make([]Field, 0, len(logger)+len(call))
+	for _, f := range logger {
+		if !hasField(call, f.Key) {
+			fs = append(fs, f)
+		}
+	}
+	return append(fs, call...)
+}
+
+// sortFields sorts the fields of each message by key; see SetSortFields
+var sortFields bool
+
+// SetSortFields sorts the fields of the logger and of the call by key in
+// every message, for output that is easier to compare. By default they are
+// written in the order they were given, which is cheaper. Static fields are
+// always sorted, and come first either way.
+func SetSortFields(enabled bool) {
+	sortFields = enabled
+}
+
+// sortedFields returns a copy of fields sorted by key. The copy keeps the
+// slices of loggers and callers intact.
+func sortedFields(fields []Field) []Field {
+	fs := append(make([]Field, 0, len(fields)), fields...)
+	sort.SliceStable(fs, func(i, j int) bool { return fs[i].Key < fs[j].Key })
+	return fs
+}
+
+// DebugfFields logs a printf-style debug message with fields added after the
+// fields of the logger. Unlike WithFields, it doesn't allocate a logger, and
+// fields is used without being copied, so a hot path can reuse a slice of
+// preallocated fields. Don't modify it until the call returns, or at all
+// with SetDeferredRendering, which renders the message later.
+func (l *Logger) DebugfFields(prefix, format string, fields []Field, v ...interface{}) {
+	if l.levelEnabled(Levels.Debug) {
+		l.log(Levels.Debug, prefix, format, v, true, fields)
+	}
+}
+
+// InfofFields logs a printf-style info message with fields; see
+// DebugfFields.
+func (l *Logger) InfofFields(prefix, format string, fields []Field, v ...interface{}) {
+	if l.levelEnabled(Levels.Info) {
+		l.log(Levels.Info, prefix, format, v, true, fields)
+	}
+}
+
+// WarnfFields logs a printf-style warn message with fields; see
+// DebugfFields.
+func (l *Logger) WarnfFields(prefix, format string, fields []Field, v ...interface{}) {
+	if l.levelEnabled(Levels.Warn) {
+		l.log(Levels.Warn, prefix, format, v, true, fields)
+	}
+}
+
+// ErrorfFields logs a printf-style error message with fields; see
+// DebugfFields.
+func (l *Logger) ErrorfFields(prefix, format string, fields []Field, v ...interface{}) {
+	if l.levelEnabled(Levels.Error) {
+		l.log(Levels.Error, prefix, format, v, true, fields)
+	}
+}
+
+// DurationFormat is how time.Duration field values are rendered; see
+// SetDurationFormat.
+type DurationFormat int
+
+const (
+	// DurationString renders durations as strings such as "1.5s".
+	DurationString DurationFormat = iota
+	// DurationMillis renders durations as a number of milliseconds, such as
+	// 1500, with a fraction below a millisecond.
+	DurationMillis
+	// DurationNanos renders durations as an integer number of nanoseconds,
+	// as encoding/json does.
+	DurationNanos
+)
+
+// durationFormat renders time.Duration field values; see SetDurationFormat
+var durationFormat = DurationString
+
+// SetDurationFormat sets how time.Duration field values are rendered in
+// every output format. The default is DurationString. Field values of type
+// time.Time are always rendered with the layout of message timestamps, see
+// SetTimeFormat.
+func SetDurationFormat(f DurationFormat) {
+	durationFormat = f
+}
+
+// fieldValue normalizes the durations and times among field values, for
+// JSON output when json is set and for text otherwise. Other values are
+// returned as they are.
+func fieldValue(v interface{}, json bool) interface{} {
+	switch v := v.(type) {
+	case time.Duration:
+		switch durationFormat {
+		case DurationMillis:
+			ms := float64(v) / float64(time.Millisecond)
+			if !json {
+				return strconv.FormatFloat(ms, 'f', -1, 64) // not 1.23e+07
+			}
+			return ms
+		case DurationNanos:
+			return int64(v)
+		}
+		return v.String()
+	case time.Time:
+		if json {
+			return jsonTime(v)
+		}
+		if timeLayout != "" {
+			return v.Format(timeLayout)
+		}
+		return v.Format(strings.TrimSpace(STDOUT_FORMAT))
+	}
+	return v
+}
+
+// fieldString renders a field value as text.
+func fieldString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(fieldValue(v, false))
+}
+
+// writeFieldsString appends fields to buf as space separated key=value pairs.
+// Values containing spaces or quotes are quoted.
+func writeFieldsString(buf *bytes.Buffer, fieldSets ...[]Field) {
+	for _, fields := range fieldSets {
+		for _, f := range fields {
+			writeFieldString(buf, f)
+		}
+	}
+}
+
+func writeFieldString(buf *bytes.Buffer, f Field) {
+	buf.WriteByte(' ')
+	buf.WriteString(f.Key)
+	buf.WriteByte('=')
+
+	s := fieldString(f.Val)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		s = strconv.Quote(s)
+	}
+	buf.WriteString(s)
+}
+
+// writeFieldsJSON adds fields to the JSON object that ends buf, which must
+// be terminated by "}\n" as written by json.Encoder.
+func writeFieldsJSON(buf *bytes.Buffer, fieldSets ...[]Field) error {
+	n := 0
+	for _, fields := range fieldSets {
+		n += len(fields)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	buf.Truncate(buf.Len() - 2)
+	for _, fields := range fieldSets {
+		if err := writeFieldsJSONObject(buf, fields); err != nil {
+			return err
+		}
+	}
+	buf.WriteString("}\n")
+
+	return nil
+}
+
+func writeFieldsJSONObject(buf *bytes.Buffer, fields []Field) error {
+	for _, f := range fields {
+		key, err := json.Marshal(jsonFieldPrefix + f.Key)
+		if err != nil {
+			return err
+		}
+		val, err := json.Marshal(fieldValue(f.Val, true))
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	return nil
+}
@@ -0,0 +1,408 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaticFields(t *testing.T) {
+	defer SetStaticFields(nil)
+	SetStaticFields(map[string]interface{}{"service": "chf", "shard": 3, "note": "two words"})
+
+	newMsg := func() *logMessage {
+		return &logMessage{time: time.Now(), le: logEntry{
+			lvl: Levels.Info,
+			fmt: "hello\n",
+			lc:  logCaller{File: "a.go", Line: 1},
+		}}
+	}
+
+	msg := newMsg()
+	if err := asString(msg); err != nil {
+		t.Fatal(err)
+	}
+	if want := `[Info] <a.go: 1> hello note="two words" service=chf shard=3`; msg.String() != want {
+		t.Errorf("expected %q but got %q", want, msg.String())
+	}
+
+	msg = newMsg()
+	if err := asJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(msg.Bytes(), &entry); err != nil {
+		t.Fatalf("cannot decode %q: %v", msg.String(), err)
+	}
+	if entry["service"] != "chf" || entry["shard"] != 3.0 || entry["message"] != "hello" {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+}
+
+func TestSetDeployment(t *testing.T) {
+	defer SetStaticFields(nil)
+	defer func(env, region string) {
+		os.Setenv("DEPLOY_ENV", env)
+		os.Setenv("DEPLOY_REGION", region)
+	}(os.Getenv("DEPLOY_ENV"), os.Getenv("DEPLOY_REGION"))
+
+	os.Setenv("DEPLOY_ENV", "staging")
+	os.Setenv("DEPLOY_REGION", "eu-west")
+
+	SetStaticFields(map[string]interface{}{"service": "chf"})
+	SetDeployment("prod", "")
+
+	want := []Field{{"env", "prod"}, {"region", "eu-west"}, {"service", "chf"}}
+	got := getStaticFields()
+	if len(got) != len(want) {
+		t.Fatalf("expected %v but got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v but got %v", want[i], got[i])
+		}
+	}
+}
+
+type codedError struct{}
+
+func (codedError) Error() string    { return "quota exceeded" }
+func (codedError) Code() string     { return "E429" }
+func (codedError) Category() string { return "throttle" }
+
+func TestErrField(t *testing.T) {
+	if fields := ErrField(nil); fields != nil {
+		t.Errorf("expected no fields for a nil error but got %v", fields)
+	}
+
+	fields := ErrField(errors.New("boom"))
+	if len(fields) != 1 || fields[0] != (Field{"error.message", "boom"}) {
+		t.Errorf("expected only error.message but got %v", fields)
+	}
+
+	fields = ErrField(codedError{})
+	want := []Field{{"error.message", "quota exceeded"}, {"error.code", "E429"}, {"error.category", "throttle"}}
+	if len(fields) != len(want) {
+		t.Fatalf("expected %v but got %v", want, fields)
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Errorf("expected %v but got %v", want[i], fields[i])
+		}
+	}
+
+	fields = ErrField(fmt.Errorf("calling billing: %w", codedError{}))
+	want = []Field{{"error.message", "calling billing: quota exceeded"}, {"error.code", "E429"}, {"error.category", "throttle"}}
+	if len(fields) != len(want) {
+		t.Fatalf("expected the codes of a wrapped error %v but got %v", want, fields)
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Errorf("expected %v but got %v", want[i], fields[i])
+		}
+	}
+}
+
+func TestSetGlobalFields(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetGlobalFields(nil)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	SetGlobalFields(map[string]interface{}{"service": "chf", "version": "1.0", "dc": "fra1"})
+	log := New(Levels.Info).WithFields(Field{"version", "1.1"}, Field{"shard", 1})
+	log.Infof("", "global")
+	log.InfofFields("", "call", []Field{{"dc", "ams1"}, {"shard", 2}})
+	log.WithFields(Field{"shard", 3}).Infof("", "derived")
+	Drain()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for i, want := range []string{
+		"global dc=fra1 service=chf version=1.1 shard=1",
+		"call service=chf version=1.1 dc=ams1 shard=2",
+		"derived dc=fra1 service=chf version=1.1 shard=3",
+	} {
+		if i >= len(lines) || !strings.HasSuffix(lines[i], want) {
+			t.Errorf("expected a line ending in %q but got %q", want, buf.String())
+		}
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	parent := New(Levels.Debug)
+	log := parent.WithFields(ErrField(codedError{})...)
+	log.Errorf("[api] ", "request failed")
+	parent.Errorf("[api] ", "no fields")
+	Drain()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines but got %q", buf.String())
+	}
+	if !strings.HasSuffix(lines[0], `request failed error.message="quota exceeded" error.code=E429 error.category=throttle`) {
+		t.Errorf("unexpected line with fields: %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "no fields") {
+		t.Errorf("expected the parent logger to be unchanged but got %q", lines[1])
+	}
+
+	var nilLog *Logger
+	if nilLog.WithFields(Field{"a", 1}) != nil {
+		t.Error("expected a nil logger to stay nil")
+	}
+}
+
+func TestWithError(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer setFormat()
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := New(Levels.Info)
+	if log.WithError(nil) != log {
+		t.Error("expected a nil error to return the logger itself")
+	}
+
+	err := fmt.Errorf("dial collector: %w", errors.New("connection refused"))
+	log.WithError(err).Errorf("[api] ", "failed to connect")
+	Drain()
+	if want := `failed to connect error="dial collector: connection refused"` + "\n"; !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("expected %q but got %q", want, buf.String())
+	}
+
+	buf.Reset()
+	format, sendJSON, jsonFieldPrefix = asJSON, true, ""
+	log.WithError(err).Errorf("[api] ", "failed to connect")
+	Drain()
+	var got struct {
+		Error string   `json:"error"`
+		Chain []string `json:"error_chain"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("expected a JSON object but got %q: %v", buf.String(), err)
+	}
+	if got.Error != "dial collector: connection refused" || len(got.Chain) != 2 || got.Chain[1] != "connection refused" {
+		t.Errorf("expected the error and its chain but got %+v", got)
+	}
+}
+
+func TestSlice(t *testing.T) {
+	defer SetSliceMaxLen(100)
+
+	type dev struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	tests := []struct {
+		v          interface{}
+		text, json string
+	}{
+		{[]string{"a", "b", "c"}, `[a b c]`, `["a","b","c"]`},
+		{[]int{}, `[]`, `[]`},
+		{[2]int{1, 2}, `[1 2]`, `[1,2]`},
+		{[]dev{{1, "x"}}, `[{1 x}]`, `[{"id":1,"name":"x"}]`},
+		{[]int{1, 2, 3, 4, 5}, `[1 2 3 ...(2 more)]`, `[1,2,3,"...(2 more)"]`},
+		{"not a slice", `not a slice`, `"not a slice"`},
+	}
+
+	SetSliceMaxLen(3)
+	for _, tt := range tests {
+		f := Slice("k", tt.v)
+		if got := fmt.Sprint(f.Val); got != tt.text {
+			t.Errorf("%v: expected text %q but got %q", tt.v, tt.text, got)
+		}
+		if got, err := json.Marshal(f.Val); err != nil || string(got) != tt.json {
+			t.Errorf("%v: expected JSON %s but got %s (%v)", tt.v, tt.json, got, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	writeFieldsString(&buf, []Field{Slice("ids", []int{1, 2})})
+	if buf.String() != ` ids="[1 2]"` {
+		t.Errorf("unexpected string field %q", buf.String())
+	}
+}
+
+func TestErrorw(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer func(f func(*logMessage) error, json bool) { format, sendJSON = f, json }(format, sendJSON)
+	defer SetErrorStacks(false)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	err := fmt.Errorf("loading config: %w", os.ErrNotExist)
+	log := New(Levels.Debug)
+	log.Errorw("[cfg] ", "100% failed", err, Field{"path", "/etc/app"})
+	Drain()
+	if want := `100% failed error="loading config: file does not exist" path=/etc/app`; !strings.HasSuffix(strings.TrimSpace(buf.String()), want) {
+		t.Errorf("expected a line ending in %q but got %q", want, buf.String())
+	}
+
+	buf.Reset()
+	format, sendJSON = asJSON, true
+	SetErrorStacks(true)
+	log.Errorw("[cfg] ", "failed", err)
+	Drain()
+
+	var entry struct {
+		Error      string   `json:"error"`
+		ErrorChain []string `json:"error_chain"`
+		Stack      string   `json:"stack"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON message but got %q: %v", buf.String(), err)
+	}
+	if entry.Error != err.Error() {
+		t.Errorf("expected error %q but got %q", err.Error(), entry.Error)
+	}
+	if want := []string{err.Error(), os.ErrNotExist.Error()}; fmt.Sprint(entry.ErrorChain) != fmt.Sprint(want) {
+		t.Errorf("expected error chain %q but got %q", want, entry.ErrorChain)
+	}
+	if !strings.HasPrefix(entry.Stack, "github.com/kentik/golog/logger.TestErrorw ") {
+		t.Errorf("expected the stack to start at the caller but got %q", entry.Stack)
+	}
+}
+
+func TestInfofFields(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetSortFields(false)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := New(Levels.Info).WithFields(Field{"service", "chf"})
+	fields := []Field{{"shard", 3}, {"device", "r1"}}
+	log.InfofFields("[api] ", "flow %d", fields, 1)
+	log.DebugfFields("[api] ", "filtered out", fields)
+	SetSortFields(true)
+	log.WarnfFields("[api] ", "flow %d", fields, 2)
+	Drain()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines but got %q", buf.String())
+	}
+	if !strings.HasSuffix(lines[0], "flow 1 service=chf shard=3 device=r1") {
+		t.Errorf("expected the fields in the order given but got %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "flow 2 device=r1 service=chf shard=3") {
+		t.Errorf("expected sorted fields but got %q", lines[1])
+	}
+	if fields[0].Key != "shard" {
+		t.Errorf("expected sorting to leave the caller's fields alone but got %v", fields)
+	}
+}
+
+func BenchmarkInfofFields(b *testing.B) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	stdhdl = io.Discard
+	log := New(Levels.Info)
+	device, shard := "r1", 3
+
+	b.Run("fields", func(b *testing.B) {
+		b.ReportAllocs()
+		fields := make([]Field, 2)
+		for i := 0; i < b.N; i++ {
+			fields[0], fields[1] = Field{"device", device}, Field{"shard", shard}
+			log.InfofFields("", "flow", fields)
+		}
+		Drain()
+	})
+	b.Run("map", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m := map[string]interface{}{"device": device, "shard": shard}
+			fields := make([]Field, 0, len(m))
+			for k, v := range m {
+				fields = append(fields, Field{k, v})
+			}
+			log.InfofFields("", "flow", fields)
+		}
+		Drain()
+	})
+	b.Run("WithFields", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			log.WithFields(Field{"device", device}, Field{"shard", shard}).Infof("", "flow")
+		}
+		Drain()
+	})
+}
+
+func TestSetDurationFormat(t *testing.T) {
+	defer SetDurationFormat(DurationString)
+	defer SetTimeFormat("")
+
+	tm := time.Date(2021, 5, 4, 3, 2, 1, 500000000, time.UTC)
+	fields := []Field{{"short", 1500 * time.Microsecond}, {"long", 3*time.Hour + 25*time.Minute}, {"at", tm}}
+	render := func() (string, string) {
+		var text, js bytes.Buffer
+		writeFieldsString(&text, fields)
+		js.WriteString(`{"m":1}` + "\n")
+		if err := writeFieldsJSON(&js, fields); err != nil {
+			t.Fatal(err)
+		}
+		return text.String(), strings.TrimSpace(js.String())
+	}
+
+	tests := []struct {
+		format     DurationFormat
+		layout     string
+		text, json string
+	}{
+		{DurationString, "", ` short=1.5ms long=3h25m0s at=2021-05-04T03:02:01.500`,
+			`{"m":1,"short":"1.5ms","long":"3h25m0s","at":"2021-05-04T03:02:01.5Z"}`},
+		{DurationMillis, "", ` short=1.5 long=12300000 at=2021-05-04T03:02:01.500`,
+			`{"m":1,"short":1.5,"long":12300000,"at":"2021-05-04T03:02:01.5Z"}`},
+		{DurationNanos, time.RFC3339, ` short=1500000 long=12300000000000 at=2021-05-04T03:02:01Z`,
+			`{"m":1,"short":1500000,"long":12300000000000,"at":"2021-05-04T03:02:01Z"}`},
+	}
+	for _, tt := range tests {
+		SetDurationFormat(tt.format)
+		if err := SetTimeFormat(tt.layout); err != nil {
+			t.Fatal(err)
+		}
+		text, js := render()
+		if text != tt.text {
+			t.Errorf("format %d: expected text %q but got %q", tt.format, tt.text, text)
+		}
+		if js != tt.json {
+			t.Errorf("format %d: expected JSON %s but got %s", tt.format, tt.json, js)
+		}
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log, id := New(Levels.Info).WithRequestID()
+	if !regexp.MustCompile(`^[a-z2-7]{16}$`).MatchString(id) {
+		t.Errorf("expected 16 characters of base32 but got %q", id)
+	}
+	log.Infof("[api] ", "handled")
+	Drain()
+	if want := "handled request_id=" + id + "\n"; !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("expected %q but got %q", want, buf.String())
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		_, id := log.WithRequestID()
+		if seen[id] {
+			t.Fatalf("expected unique IDs but got %q twice", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,42 @@
+package logger
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// gelfEntry is the shape of a message when logging as GELF 1.1. Additional
+// fields are prefixed with an underscore.
+type gelfEntry struct {
+	Version      string  `json:"version"`
+	Host         string  `json:"host"`
+	ShortMessage string  `json:"short_message"`
+	Timestamp    float64 `json:"timestamp"` // seconds since the epoch
+	Level        int     `json:"level"`     // syslog severity
+	Name         string  `json:"_name,omitempty"`
+	Prefix       string  `json:"_prefix,omitempty"`
+	Caller       string  `json:"_caller,omitempty"`
+}
+
+// asGELF renders the message as a GELF 1.1 object followed by a newline,
+// with the fields of the message as additional fields.
+func asGELF(msg *logMessage) error {
+	le := &msg.le
+	entry := gelfEntry{
+		Version:      "1.1",
+		Host:         hostname,
+		ShortMessage: jsonMessage(le),
+		Timestamp:    float64(msg.time.UnixNano()/1e6) / 1e3,
+		Level:        levelSysLog[le.lvl],
+		Name:         logNameString,
+		Prefix:       strings.TrimSpace(le.pre),
+	}
+	if le.lc.File != "" {
+		entry.Caller = le.lc.String()
+	}
+	if err := json.NewEncoder(msg).Encode(&entry); err != nil {
+		return err
+	}
+
+	return writeFieldsJSON(&msg.Buffer, staticFieldsFor(le), le.fields, promotedFields(le, entry.ShortMessage), msg.meta)
+}
@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func Test_asGELF(t *testing.T) {
+	defer func(name string) { logNameString = name }(logNameString)
+	defer func(prefix string) { jsonFieldPrefix = prefix }(jsonFieldPrefix)
+	logNameString = "chf"
+	jsonFieldPrefix = "_"
+
+	msg := &logMessage{
+		time: time.Unix(1620097321, 123456789),
+		le: logEntry{
+			lvl:    Levels.Warn,
+			pre:    " [pre] ",
+			fmt:    "hello %s\n",
+			fmtV:   []interface{}{"world"},
+			lc:     logCaller{File: "a/b.go", Line: 229},
+			fields: []Field{{"shard", 3}},
+		},
+	}
+	if err := asGELF(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(msg.Bytes(), &got); err != nil {
+		t.Fatalf("expected a JSON object but got %q: %v", msg.String(), err)
+	}
+	want := map[string]interface{}{
+		"version":       "1.1",
+		"host":          hostname,
+		"short_message": "hello world",
+		"timestamp":     1620097321.123,
+		"level":         4.0,
+		"_name":         "chf",
+		"_prefix":       "[pre]",
+		"_caller":       "a/b.go:229",
+		"_shard":        3.0,
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %v but got %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("expected %s=%v but got %v", k, v, got[k])
+		}
+	}
+}
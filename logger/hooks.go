@@ -0,0 +1,33 @@
+package logger
+
+// Hooks are called where messages are lost, for alerting on it. They must be
+// cheap and must not log: the drop hook runs when the message pool is empty,
+// and the error hook runs on the writer goroutine, so logging from either
+// can only drop more messages or, with SetBlockOnFull, deadlock. Have them
+// bump counters or metrics instead.
+var (
+	dropHook  func(level Level, prefix, format string)
+	errorHook func(err error)
+)
+
+// SetDropHook sets a function called on the logging goroutine for each
+// message dropped because the message pool is empty, with its level, prefix
+// and unformatted format string. A nil fn removes the hook. It is meant to be
+// set at startup; see the notes on hooks above.
+func SetDropHook(fn func(level Level, prefix, format string)) {
+	dropHook = fn
+}
+
+// SetErrorHook sets a function called on the writer goroutine for each
+// message that could not be written to an output. A nil fn removes the hook.
+// It is meant to be set at startup; see the notes on hooks above.
+func SetErrorHook(fn func(err error)) {
+	errorHook = fn
+}
+
+// reportWriteError calls the error hook for a failed write.
+func reportWriteError(err error) {
+	if err != nil && errorHook != nil {
+		errorHook(err)
+	}
+}
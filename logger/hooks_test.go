@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestSetDropHook(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer Configure(NumMessages)
+	defer SetDropHook(nil)
+
+	var dropped []string
+	SetDropHook(func(level Level, prefix, format string) {
+		dropped = append(dropped, level.String()+" "+prefix+format)
+	})
+
+	Configure(1)
+	w := &blockingWriter{release: make(chan struct{})}
+	stdhdl = w
+
+	log := New(Levels.Info)
+	var err error
+	for i := 0; i < 4 && err == nil; i++ { // the writer may hold one message
+		err = log.TryInfof("[hook] ", "flow %d", i)
+	}
+	close(w.release)
+	Drain()
+
+	if err != ErrMessageDropped || len(dropped) != 1 || dropped[0] != "Info [hook] flow %d" {
+		t.Errorf("expected the drop hook to see the dropped message but got %q (%v)", dropped, err)
+	}
+}
+
+func TestSetErrorHook(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetErrorHook(nil)
+
+	var errs []error
+	SetErrorHook(func(err error) { errs = append(errs, err) })
+	stdhdl = failingWriter{}
+
+	New(Levels.Info).Infof("", "lost")
+	Drain()
+
+	if len(errs) != 1 || errs[0].Error() != "disk full" {
+		t.Errorf("expected the error hook to see the write error but got %v", errs)
+	}
+}
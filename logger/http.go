@@ -0,0 +1,127 @@
+package logger
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// redactedValue replaces the values of redacted HTTP headers.
+const redactedValue = "***"
+
+// DefaultHTTPRedactHeaders are the headers redacted unless changed with
+// SetHTTPRedactHeaders.
+var DefaultHTTPRedactHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// httpRedactHeaders holds the set of canonical header names to redact, as a
+// map[string]struct{}.
+var httpRedactHeaders atomic.Value
+
+// SetHTTPRedactHeaders sets the headers whose values are replaced with "***"
+// when logged with HeaderField or RedactHeaders, replacing the defaults. Pass
+// nil to log all headers in full.
+func SetHTTPRedactHeaders(headers []string) {
+	set := make(map[string]struct{}, len(headers))
+	for _, h := range headers {
+		set[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	httpRedactHeaders.Store(set)
+}
+
+// RedactHeaders returns a copy of h with the values of the redacted headers
+// replaced, keeping the header names and number of values.
+func RedactHeaders(h http.Header) http.Header {
+	redact, _ := httpRedactHeaders.Load().(map[string]struct{})
+
+	out := make(http.Header, len(h))
+	for k, vs := range h {
+		if _, ok := redact[http.CanonicalHeaderKey(k)]; ok {
+			redacted := make([]string, len(vs))
+			for i := range redacted {
+				redacted[i] = redactedValue
+			}
+			vs = redacted
+		}
+		out[k] = vs
+	}
+	return out
+}
+
+// HeaderField returns a field logging the HTTP headers h, with sensitive
+// headers redacted.
+func HeaderField(key string, h http.Header) Field {
+	return Field{key, RedactHeaders(h)}
+}
+
+// AccessFields describes a served request for Logger.Access. Empty values
+// are logged as "-" in string output and left out of JSON output.
+type AccessFields struct {
+	Method     string
+	Path       string
+	Proto      string // such as "HTTP/1.1"
+	Status     int
+	Bytes      int64 // size of the response body
+	Duration   time.Duration
+	RemoteAddr string
+	User       string
+	Referer    string
+	UserAgent  string
+}
+
+// Access logs a served request at the Access level, subject to the access
+// log sampling of the logger. String output gets a line in the combined log
+// format followed by the duration:
+//
+//	10.0.0.1 - alice "GET /api/v5 HTTP/1.1" 200 512 "-" "curl/7.68.0" 1.5ms
+//
+// JSON output gets "GET /api/v5 200" as the message and every value as a
+// field, so access logs can be queried by status or duration.
+func (l *Logger) Access(a AccessFields) {
+	if l == nil {
+		return
+	}
+	if sendJSON {
+		l.log(Levels.Access, "", "%s %s %d", []interface{}{a.Method, a.Path, a.Status}, true, a.fields())
+		return
+	}
+	l.log(Levels.Access, "", `%s - %s "%s %s %s" %d %d "%s" "%s" %s`, []interface{}{
+		orDash(a.RemoteAddr), orDash(a.User), orDash(a.Method), orDash(a.Path), orDash(a.Proto),
+		a.Status, a.Bytes, orDash(a.Referer), orDash(a.UserAgent), fieldString(a.Duration),
+	}, true, nil)
+}
+
+// fields returns the access log values as fields, without the empty ones.
+func (a *AccessFields) fields() []Field {
+	fields := make([]Field, 0, 10)
+	add := func(key, val string) {
+		if val != "" {
+			fields = append(fields, Field{key, val})
+		}
+	}
+	add("method", a.Method)
+	add("path", a.Path)
+	add("proto", a.Proto)
+	fields = append(fields, Field{"status", a.Status}, Field{"bytes", a.Bytes}, Field{"duration", a.Duration})
+	add("remote_addr", a.RemoteAddr)
+	add("user", a.User)
+	add("referer", a.Referer)
+	add("user_agent", a.UserAgent)
+	return fields
+}
+
+// orDash returns s, or "-" if it is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	SetHTTPRedactHeaders(DefaultHTTPRedactHeaders)
+}
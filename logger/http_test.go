@@ -0,0 +1,93 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	defer SetHTTPRedactHeaders(DefaultHTTPRedactHeaders)
+
+	h := http.Header{
+		"Authorization": {"Bearer secret"},
+		"Set-Cookie":    {"a=1", "b=2"},
+		"Content-Type":  {"text/plain"},
+		"X-Api-Key":     {"key"},
+	}
+
+	got := HeaderField("headers", h).Val.(http.Header)
+	want := http.Header{
+		"Authorization": {"***"},
+		"Set-Cookie":    {"***", "***"},
+		"Content-Type":  {"text/plain"},
+		"X-Api-Key":     {"key"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v but got %v", want, got)
+	}
+	if h.Get("Authorization") != "Bearer secret" {
+		t.Error("expected the original headers to be left alone")
+	}
+
+	SetHTTPRedactHeaders([]string{"x-api-key"})
+	got = RedactHeaders(h)
+	if got.Get("X-Api-Key") != "***" || got.Get("Authorization") != "Bearer secret" {
+		t.Errorf("expected only X-Api-Key to be redacted but got %v", got)
+	}
+}
+
+func TestAccess(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer func(f func(*logMessage) error, json bool) { format, sendJSON = f, json }(format, sendJSON)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	req := AccessFields{
+		Method:     "GET",
+		Path:       "/api/v5",
+		Proto:      "HTTP/1.1",
+		Status:     200,
+		Bytes:      512,
+		Duration:   1500 * time.Microsecond,
+		RemoteAddr: "10.0.0.1",
+		UserAgent:  "curl/7.68.0",
+	}
+	log := New(Levels.Info)
+	log.Access(req)
+	Drain()
+	want := `> 10.0.0.1 - - "GET /api/v5 HTTP/1.1" 200 512 "-" "curl/7.68.0" 1.5ms`
+	if !strings.Contains(buf.String(), "[Access] <") || !strings.Contains(buf.String(), want) {
+		t.Errorf("expected a combined log line %q but got %q", want, buf.String())
+	}
+
+	buf.Reset()
+	format, sendJSON = asJSON, true
+	log.Access(req)
+	Drain()
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON message but got %q: %v", buf.String(), err)
+	}
+	if entry["message"] != "GET /api/v5 200" || entry["status"] != 200.0 || entry["duration"] != "1.5ms" || entry["user_agent"] != "curl/7.68.0" {
+		t.Errorf("unexpected access entry %v", entry)
+	}
+	if _, ok := entry["referer"]; ok {
+		t.Errorf("expected empty values to be left out but got %v", entry)
+	}
+
+	buf.Reset()
+	log.SetAccessLogSample(2)
+	for i := 0; i < 4; i++ {
+		log.Access(req)
+	}
+	Drain()
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Errorf("expected every other access log to be sampled out but got %d lines", n)
+	}
+}
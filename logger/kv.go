@@ -0,0 +1,133 @@
+package logger
+
+import (
+	"strconv"
+	"strings"
+)
+
+// promoteKVFields adds key=value pairs from the message text to JSON
+// messages as fields; see SetPromoteKVFields
+var promoteKVFields bool
+
+// SetPromoteKVFields makes JSON and GELF messages carry the key=value pairs
+// found in the message text as fields too, so a message logged as
+// "deviceID=%d latency=%s" can be searched by deviceID. The message itself
+// is kept as it is. Values are promoted as strings, and may be quoted as
+// with %q. Pairs are only taken from whole words starting with a plain key,
+// so URLs with query strings and the like are left alone, and keys that are
+// already fields of the message, or one of the standard keys, are skipped.
+func SetPromoteKVFields(enabled bool) {
+	promoteKVFields = enabled
+}
+
+// promotedFields returns the key=value pairs of the message text m of le
+// that should be added to its JSON object; see SetPromoteKVFields.
+func promotedFields(le *logEntry, m string) []Field {
+	if !promoteKVFields || strings.IndexByte(m, '=') < 0 {
+		return nil
+	}
+	var fields []Field
+	for _, f := range kvFields(m) {
+		if !reservedJSONKey(f.Key) && !hasField(le.fields, f.Key) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// kvFields parses the key=value pairs out of s. A pair starts a word, its
+// key is made of letters, digits, '_', '.' and '-', and its value runs to
+// the next space, tab or newline, or is a Go quoted string.
+func kvFields(s string) []Field {
+	var fields []Field
+	for i := 0; i < len(s); {
+		// skip to the start of the next word
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		start := i
+		for i < len(s) && isKeyByte(s[i]) {
+			i++
+		}
+		if i == start || i == len(s) || s[i] != '=' {
+			i = nextSpace(s, i)
+			continue
+		}
+		key := s[start:i]
+		i++ // skip '='
+
+		if i < len(s) && s[i] == '"' {
+			end := quoteEnd(s, i)
+			if end < 0 || (end < len(s) && !isSpace(s[end])) {
+				i = nextSpace(s, i)
+				continue
+			}
+			if val, err := strconv.Unquote(s[i:end]); err == nil {
+				fields = append(fields, Field{key, val})
+			}
+			i = end
+			continue
+		}
+
+		end := nextSpace(s, i)
+		if end > i {
+			fields = append(fields, Field{key, s[i:end]})
+		}
+		i = end
+	}
+	return fields
+}
+
+// isKeyByte reports whether c may be part of a promoted key.
+func isKeyByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == '-'
+}
+
+// isSpace reports whether c separates words.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// nextSpace returns the index of the first separator in s at or after i, or
+// len(s).
+func nextSpace(s string, i int) int {
+	for i < len(s) && !isSpace(s[i]) {
+		i++
+	}
+	return i
+}
+
+// quoteEnd returns the index just past the closing quote of the quoted
+// string starting at s[i], or -1 if it isn't closed.
+func quoteEnd(s string, i int) int {
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1
+		}
+	}
+	return -1
+}
+
+// reservedJSONKey reports whether key is one of the standard keys of JSON
+// messages, which promoted fields must not repeat.
+func reservedJSONKey(key string) bool {
+	switch key {
+	case "time", "name", "level", "prefix", "caller", "message":
+		return true
+	}
+	return false
+}
+
+// hasField reports whether fields has a field with the key.
+func hasField(fields []Field, key string) bool {
+	for _, f := range fields {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
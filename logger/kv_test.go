@@ -0,0 +1,83 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_kvFields(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []Field
+	}{
+		{"deviceID=42 latency=1.5s", []Field{{"deviceID", "42"}, {"latency", "1.5s"}}},
+		{`flow from addr="10.0.0.1 port 80" ok`, []Field{{"addr", "10.0.0.1 port 80"}}},
+		{`msg="say \"hi\"" n=1`, []Field{{"msg", `say "hi"`}, {"n", "1"}}},
+		{"fetching https://host/path?a=1&b=2 failed", nil},
+		{"x == y", nil},
+		{"empty= next=1", []Field{{"next", "1"}}},
+		{`open="unterminated rest=2`, []Field{{"rest", "2"}}},
+		{"multi\nline=1\tk.v-2=x", []Field{{"line", "1"}, {"k.v-2", "x"}}},
+		{"no pairs here", nil},
+	}
+	for _, tt := range tests {
+		got := kvFields(tt.in)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%q: expected %v but got %v", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestSetPromoteKVFields(t *testing.T) {
+	defer SetPromoteKVFields(false)
+	newMsg := func() *logMessage {
+		return &logMessage{time: time.Now(), le: logEntry{
+			lvl:    Levels.Info,
+			fmt:    "deviceID=%d latency=%s name=x user=y",
+			fmtV:   []interface{}{42, time.Second},
+			fields: []Field{{"user", "explicit"}},
+		}}
+	}
+
+	SetPromoteKVFields(true)
+	msg := newMsg()
+	if err := asJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(msg.Bytes(), &entry); err != nil {
+		t.Fatalf("cannot decode %q: %v", msg.String(), err)
+	}
+	if entry["deviceID"] != "42" || entry["latency"] != "1s" {
+		t.Errorf("expected promoted fields in %q", msg.String())
+	}
+	if entry["message"] != "deviceID=42 latency=1s name=x user=y" {
+		t.Errorf("expected the full message to be kept but got %v", entry["message"])
+	}
+	if entry["name"] == "x" || entry["user"] != "explicit" {
+		t.Errorf("expected standard keys and explicit fields to win in %q", msg.String())
+	}
+
+	SetPromoteKVFields(false)
+	msg = newMsg()
+	if err := asJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	entry = nil
+	if err := json.Unmarshal(msg.Bytes(), &entry); err != nil {
+		t.Fatalf("cannot decode %q: %v", msg.String(), err)
+	}
+	if _, ok := entry["deviceID"]; ok {
+		t.Errorf("expected no promoted fields when disabled but got %q", msg.String())
+	}
+}
+
+func Benchmark_kvFields(b *testing.B) {
+	m := `flow export from device deviceID=42 latency=1.5s addr="10.0.0.1" to https://host/path?a=1`
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		kvFields(m)
+	}
+}
@@ -0,0 +1,98 @@
+package logger
+
+import (
+	"os"
+	"runtime/debug"
+)
+
+// emitLifecycle is set when the logger logs its own start and close; see
+// SetEmitLifecycle.
+var emitLifecycle bool
+
+// SetEmitLifecycle makes the logger log a "log started" message with its
+// version, pool size, format and output, and a "log closed" message with the
+// counts of Stats on Close, so operators can confirm the configuration and
+// that nothing was dropped from the logs themselves. They are logged at
+// info level with the "[meta log]" prefix, rendered in the configured format
+// and not teed.
+//
+// The started message is logged when lifecycle messages are enabled, and
+// again on Reinit, so call it once the output and format are configured.
+// It is off by default.
+func SetEmitLifecycle(enabled bool) {
+	wasEnabled := emitLifecycle
+	emitLifecycle = enabled
+	if enabled && !wasEnabled {
+		logStarted()
+	}
+}
+
+// logStarted logs the lifecycle message describing the configuration.
+func logStarted() {
+	logLifecycle("log started",
+		Field{"version", libraryVersion()},
+		Field{"pool_size", poolSize},
+		Field{"format", formatName},
+		Field{"output", outputName()},
+	)
+}
+
+// logClosed logs the lifecycle message with the counts of Stats.
+func logClosed() {
+	logs, _, drop, errs := Stats()
+	logLifecycle("log closed",
+		Field{"logs", logs},
+		Field{"drops", drop},
+		Field{"errors", errs},
+	)
+}
+
+// logLifecycle logs a lifecycle message without a caller, which would only
+// point here.
+func logLifecycle(msg string, fields ...Field) {
+	_ = New(Levels.Info).logAt(logCaller{}, Levels.Info, "[meta log] ", msg, nil, false, fields)
+}
+
+// libraryVersion returns the module version of golog the program was built
+// with, or "(devel)" when it is built from the golog module itself.
+func libraryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/kentik/golog" {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			return dep.Version
+		}
+	}
+	return info.Main.Version
+}
+
+// outputName returns the output declared with SetOutputMode, or else the
+// default output messages are written to.
+func outputName() string {
+	switch outputMode {
+	case ModeStdout:
+		return "stdout"
+	case ModeSyslog:
+		return "syslog"
+	case ModeSocket:
+		return "socket"
+	}
+
+	switch {
+	case stdhdl == os.Stdout:
+		return "stdout"
+	case stdhdl == os.Stderr:
+		return "stderr"
+	case stdhdl != nil:
+		return "writer"
+	case customSock != nil:
+		return "socket"
+	default:
+		return "syslog"
+	}
+}
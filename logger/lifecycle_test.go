@@ -0,0 +1,39 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSetEmitLifecycle(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetEmitLifecycle(false)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	SetEmitLifecycle(true)
+	Drain()
+	started := buf.String()
+	if !strings.Contains(started, "[Info] [meta log] log started version=") || !strings.Contains(started, "format=string") || !strings.Contains(started, "output=writer") {
+		t.Errorf("expected a started message with the configuration but got %q", started)
+	}
+	SetEmitLifecycle(true)
+	Drain()
+	if buf.String() != started {
+		t.Errorf("expected the started message only when enabled but got %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	Reinit()
+	Drain()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "[meta log] log closed logs=") || !strings.Contains(lines[1], "log started") {
+		t.Errorf("expected a closed message and a started message on Reinit but got %q", buf.String())
+	}
+}
@@ -7,9 +7,17 @@ package logger
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Level int
@@ -49,7 +57,8 @@ var (
 	}
 
 	// CfgLevels maps strings to Level. The intent is to use this during config
-	// time.
+	// time. Looking up a name that is not in it gives Levels.Off, which turns
+	// logging off, so prefer ParseLevel.
 	CfgLevels = map[string]Level{
 		"access": Levels.Access,
 		"off":    Levels.Off,
@@ -60,9 +69,30 @@ var (
 		"debug":  Levels.Debug,
 	}
 
+	// levelAliases are the other names ParseLevel accepts
+	levelAliases = map[string]Level{
+		"warning":       Levels.Warn,
+		"err":           Levels.Error,
+		"informational": Levels.Info,
+		"fatal":         Levels.Panic,
+	}
+
 	logCount  uint64 // number of messages attempted on all loggers
 	dropCount uint64 // number of messages dropped on all loggers
 	errCount  uint64 // number of errors seen across all loggers
+
+	// levelCounts breaks logCount down by level, indexed by level from
+	// Access to Debug; see LevelStats
+	levelCounts [7]uint64
+
+	teeDropCount  uint64 // number of messages dropped because the tee was full
+	truncateCount uint64 // number of messages cut down to maxMessageBytes
+
+	includeGoroutineID, _ = strconv.ParseBool(os.Getenv("KENTIK_LOG_GOID"))
+
+	// includeCaller looks up the file and line of logging calls; see
+	// SetIncludeCaller
+	includeCaller = true
 )
 
 // Stats returns the current status of the logger. It reports:
@@ -74,94 +104,409 @@ func Stats() (logs, pending, drop, errs uint64) {
 	return atomic.LoadUint64(&logCount), uint64(len(messages)), atomic.LoadUint64(&dropCount), atomic.LoadUint64(&errCount)
 }
 
+// TeeDrops returns the number of messages that were not sent to the tee
+// because it was full, since startup. These are not counted as errors or
+// drops in Stats.
+func TeeDrops() uint64 {
+	return atomic.LoadUint64(&teeDropCount)
+}
+
+// TeeDropsFor returns the number of messages that were not sent to the tee
+// ch because it was full, since it was added with SetTee or AddTee.
+func TeeDropsFor(ch chan string) uint64 {
+	for _, t := range loadTees().str {
+		if t.ch == ch {
+			return atomic.LoadUint64(&t.drops)
+		}
+	}
+	return 0
+}
+
+// EntryTeeDropsFor returns the number of entries that were not sent to the
+// entry tee ch because it was full, since it was added with AddEntryTee.
+func EntryTeeDropsFor(ch chan TeeEntry) uint64 {
+	for _, t := range loadTees().entries {
+		if t.ch == ch {
+			return atomic.LoadUint64(&t.drops)
+		}
+	}
+	return 0
+}
+
+// LevelStats returns the number of messages attempted at each level on all
+// loggers since startup, including Access, which add up to the logs of
+// Stats. Levels nothing was logged at are left out.
+func LevelStats() map[Level]uint64 {
+	counts := make(map[Level]uint64, len(levelCounts))
+	for i := range levelCounts {
+		if n := atomic.LoadUint64(&levelCounts[i]); n > 0 {
+			counts[Level(i)+Levels.Access] = n
+		}
+	}
+	return counts
+}
+
+// countLog counts a message attempted at level in the logs of Stats and
+// LevelStats.
+func countLog(level Level) {
+	atomic.AddUint64(&logCount, 1)
+	if i := level - Levels.Access; i >= 0 && int(i) < len(levelCounts) {
+		atomic.AddUint64(&levelCounts[i], 1)
+	}
+}
+
+// Truncations returns the number of messages that were cut down to the
+// limit set with SetMaxMessageBytes, since startup. They are still counted
+// as logs in Stats.
+func Truncations() uint64 {
+	return atomic.LoadUint64(&truncateCount)
+}
+
 type Logger struct {
 	level               Level
 	sample, sampleCount uint64 // counters to allow us to sample every "sample" access logs
+	sampleSkipped       uint64 // access logs sampled out since the last one kept
+	limiter             *rateLimiter
+	fields              []Field // added to every message; never modified once set
+	logCount, dropCount uint64  // like the global counters, for this logger only
+	sampling            *levelSampling
+	callerSkip          int           // extra stack frames to skip for the caller; see WithCallerSkip
+	prefixFunc          func() string // see SetPrefixFunc
+	name                string        // comes before the prefix of every message; see Named
+	out                 sink          // replaces the default output; see SetOutput
+
+	// tempLevel is the level set with SetLevelFor, or noLevelOverride, and
+	// tempTimer reverts it; tempMu guards tempTimer
+	tempLevel int32 // atomic
+	tempMu    sync.Mutex
+	tempTimer *time.Timer
+}
+
+// levelSampling keeps every rate-th message of each level, indexed by level
+// from Off to Debug. A rate of 0 or 1 keeps every message.
+type levelSampling struct {
+	rate, count [6]uint64
+}
+
+// keep reports whether the next message at level should be logged.
+func (ls *levelSampling) keep(level Level) bool {
+	rate := atomic.LoadUint64(&ls.rate[level])
+	return rate <= 1 || atomic.AddUint64(&ls.count[level], 1)%rate == 0
 }
 
 func (level Level) String() string {
 	return levelMap[level]
 }
 
+// ParseLevel returns the level named by s, ignoring case and surrounding
+// space. Besides the names in CfgLevels, it accepts the common aliases
+// "warning", "err", "informational" and "fatal". Unlike a CfgLevels lookup,
+// it returns an error for an unknown name rather than Levels.Off.
+func ParseLevel(s string) (Level, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	if level, ok := CfgLevels[name]; ok {
+		return level, nil
+	}
+	if level, ok := levelAliases[name]; ok {
+		return level, nil
+	}
+	return Levels.Off, fmt.Errorf("unknown log level %q", s)
+}
+
+// LevelFromEnv returns the level named by the environment variable key, such
+// as KENTIK_LOG_LEVEL=debug, matched case-insensitively against CfgLevels.
+// It returns def when the variable is unset or not a level name.
+func LevelFromEnv(key string, def Level) Level {
+	if level, ok := CfgLevels[strings.ToLower(strings.TrimSpace(os.Getenv(key)))]; ok {
+		return level
+	}
+	return def
+}
+
 func New(level Level) (l *Logger) {
 	l = new(Logger)
 	l.level = level
 	l.sample = 1
+	l.tempLevel = noLevelOverride
 
 	return
 }
 
-func (l *Logger) log(level Level, prefix, format string, v []interface{}, tee bool) {
+// clone returns a copy of the logger with the same settings.
+func (l *Logger) clone() *Logger {
+	child := &Logger{
+		level:      l.level,
+		sample:     atomic.LoadUint64(&l.sample),
+		limiter:    l.limiter,
+		fields:     l.fields,
+		callerSkip: l.callerSkip,
+		prefixFunc: l.prefixFunc,
+		name:       l.name,
+		out:        l.out,
+		tempLevel:  noLevelOverride,
+	}
+	if l.sampling != nil {
+		child.sampling = &levelSampling{}
+		for i := range l.sampling.rate {
+			child.sampling.rate[i] = atomic.LoadUint64(&l.sampling.rate[i])
+		}
+	}
+	return child
+}
+
+// WithCallerSkip returns a copy of the logger that reports the caller n
+// frames further up the stack, so that a function wrapping the logger
+// reports where it was called from rather than itself. Skips add up.
+func (l *Logger) WithCallerSkip(n int) *Logger {
+	if l == nil {
+		return nil
+	}
+
+	child := l.clone()
+	child.callerSkip += n
+	return child
+}
+
+// Named returns a copy of the logger whose messages are prefixed with its
+// name, followed by the prefix given when logging. The name of a logger
+// named from another one is the name of the parent, a space and suffix, so
+// New(Levels.Info).Named("[CHF]").Named("(netclass)") logs "[api] " messages
+// with the prefix "[CHF] (netclass) [api] ". The copy starts with the level,
+// sampling and fields of the logger, and is independent of it afterwards.
+func (l *Logger) Named(suffix string) *Logger {
+	if l == nil {
+		return nil
+	}
+
+	child := l.clone()
+	switch {
+	case suffix == "":
+	case child.name == "":
+		child.name = suffix
+	default:
+		child.name += " " + suffix
+	}
+	return child
+}
+
+// levelEnabled reports whether messages at level, other than Access, would be
+// logged.
+func (l *Logger) levelEnabled(level Level) bool {
+	return l != nil && level <= l.effectiveLevel() && level != Levels.Off
+}
+
+// DebugEnabled reports whether the logger logs debug messages. The
+// arguments of a logging call are allocated even when its level is disabled,
+// so guard logging in hot paths with it:
+//
+//	if log.DebugEnabled() {
+//		log.Debugf(prefix, "flow %v from %v", flow, addr)
+//	}
+func (l *Logger) DebugEnabled() bool {
+	return l.levelEnabled(Levels.Debug)
+}
+
+// InfoEnabled reports whether the logger logs info messages; see DebugEnabled.
+func (l *Logger) InfoEnabled() bool {
+	return l.levelEnabled(Levels.Info)
+}
+
+// WarnEnabled reports whether the logger logs warn messages; see DebugEnabled.
+func (l *Logger) WarnEnabled() bool {
+	return l.levelEnabled(Levels.Warn)
+}
+
+// ErrorEnabled reports whether the logger logs error messages; see
+// DebugEnabled.
+func (l *Logger) ErrorEnabled() bool {
+	return l.levelEnabled(Levels.Error)
+}
+
+// log queues a message. fields are added for this message only, after the
+// fields of the logger.
+func (l *Logger) log(level Level, prefix, format string, v []interface{}, tee bool, fields []Field) error {
+	if !l.keep(level) {
+		return nil
+	}
+	if level == Levels.Access {
+		fields = l.accessSampleFields(fields)
+	}
+	var caller logCaller
+	if includeCaller {
+		_, file, line, _ := runtime.Caller(2 + l.callerSkip)
+		caller = logCaller{File: stripFile(file), Line: line}
+	}
+	return l.logAt(caller, level, prefix, format, v, tee, fields)
+}
+
+// keep reports whether a message at level passes the level and sampling
+// checks of the logger. It counts the message for sampling.
+func (l *Logger) keep(level Level) bool {
 	switch {
 	case l == nil:
-		return
+		return false
 	case level == Levels.Access:
 		count := atomic.AddUint64(&l.sampleCount, 1)
 		if l.sample == 0 || count%l.sample != 0 {
-			return
+			atomic.AddUint64(&l.sampleSkipped, 1)
+			return false
 		}
-	case level > l.level, level == Levels.Off:
-		return
+	case level > l.effectiveLevel(), level == Levels.Off:
+		return false
+	case l.sampling != nil && !l.sampling.keep(level):
+		return false
+	}
+	return true
+}
+
+// logAt queues a message that passed keep, logged by caller.
+func (l *Logger) logAt(caller logCaller, level Level, prefix, format string, v []interface{}, tee bool, fields []Field) error {
+	if prefix == "" && l.prefixFunc != nil {
+		prefix = l.prefixFunc()
+	}
+	if l.name != "" {
+		prefix = l.name + " " + prefix
+	}
+	if l.limiter != nil && !l.limiter.allow(level, prefix, format, caller) {
+		return nil
+	}
+	le := &logEntry{lvl: level, pre: prefix, fmt: format, fmtV: v, lc: caller, tee: tee, fields: l.fields, out: l.out}
+	if len(fields) > 0 {
+		if len(le.fields) > 0 {
+			le.fields = mergeFields(le.fields, fields)
+		} else {
+			le.fields = fields
+		}
+	}
+	if sortFields && len(le.fields) > 1 {
+		le.fields = sortedFields(le.fields)
+	}
+	if includeGoroutineID {
+		le.goid = goroutineID()
 	}
 
-	_, file, line, _ := runtime.Caller(2)
-	caller := logCaller{stripFile(file), line}
-	_ = queueMsg(&logEntry{level, prefix, format, v, caller, tee})
-	// TODO: instead of ignoring error from queueMsg(), send it to stderr|stdout?
+	atomic.AddUint64(&l.logCount, 1)
+	err := queueMsg(le)
+	if err == ErrMessageDropped {
+		atomic.AddUint64(&l.dropCount, 1)
+	}
+	return err
+}
+
+// Stats returns the number of messages this logger attempted to write and
+// the number of those that were dropped because the write queue was full,
+// since it was created. Loggers derived from it count separately.
+func (l *Logger) Stats() (logs, drop uint64) {
+	if l == nil {
+		return 0, 0
+	}
+	return atomic.LoadUint64(&l.logCount), atomic.LoadUint64(&l.dropCount)
 }
 
 func (l *Logger) Printf(level Level, prefix, format string, v ...interface{}) {
-	l.log(level, prefix, format, v, true)
+	l.log(level, prefix, format, v, true, nil)
 }
 
 // Debug logs a printf-style debug message (deprecated, please use Debugf)
 func (l *Logger) Debug(prefix, format string, v ...interface{}) {
-	l.log(Levels.Debug, prefix, format, v, true)
+	l.log(Levels.Debug, prefix, format, v, true, nil)
 }
 
 // Debugf logs a printf-style debug message
 func (l *Logger) Debugf(prefix, format string, v ...interface{}) {
-	l.log(Levels.Debug, prefix, format, v, true)
+	l.log(Levels.Debug, prefix, format, v, true, nil)
 }
 
 // Info logs a printf-style info message (deprecated, please use Infof)
 func (l *Logger) Info(prefix, format string, v ...interface{}) {
-	l.log(Levels.Info, prefix, format, v, true)
+	l.log(Levels.Info, prefix, format, v, true, nil)
 }
 
 // Infof logs a printf-style info message
 func (l *Logger) Infof(prefix, format string, v ...interface{}) {
-	l.log(Levels.Info, prefix, format, v, true)
+	l.log(Levels.Info, prefix, format, v, true, nil)
 }
 
 // Warn logs a printf-style warn message (deprecated, please use Warnf)
 func (l *Logger) Warn(prefix, format string, v ...interface{}) {
-	l.log(Levels.Warn, prefix, format, v, true)
+	l.log(Levels.Warn, prefix, format, v, true, nil)
 }
 
 // Warnf logs a printf-style warn message
 func (l *Logger) Warnf(prefix, format string, v ...interface{}) {
-	l.log(Levels.Warn, prefix, format, v, true)
+	l.log(Levels.Warn, prefix, format, v, true, nil)
 }
 
 // Error logs a printf-style error message (deprecated, please use Errorf)
 func (l *Logger) Error(prefix, format string, v ...interface{}) {
-	l.log(Levels.Error, prefix, format, v, true)
+	l.log(Levels.Error, prefix, format, v, true, nil)
 }
 
 // Errorf logs a printf-style error message
 func (l *Logger) Errorf(prefix, format string, v ...interface{}) {
-	l.log(Levels.Error, prefix, format, v, true)
+	l.log(Levels.Error, prefix, format, v, true, nil)
 }
 
-// Panic logs a printf-style panic message (deprecated, please use Panicf)
+// Panic logs a printf-style panic message (deprecated, please use Panicf).
+// Unlike Panicf, it only logs and does not panic.
 func (l *Logger) Panic(prefix, format string, v ...interface{}) {
-	l.log(Levels.Panic, prefix, format, v, true)
+	l.log(Levels.Panic, prefix, format, v, true, nil)
+}
+
+// TryDebugf logs a printf-style debug message like Debugf, returning
+// ErrMessageDropped or ErrLogFullBuf if it could not be queued. Messages
+// filtered out by the level, sampling or rate limit are not errors.
+func (l *Logger) TryDebugf(prefix, format string, v ...interface{}) error {
+	return l.log(Levels.Debug, prefix, format, v, true, nil)
 }
 
-// Panicf logs a printf-style panic message
-func (l *Logger) Panicf(prefix, format string, v ...interface{}) {
-	l.log(Levels.Panic, prefix, format, v, true)
+// TryInfof logs a printf-style info message like Infof, returning an error
+// if it could not be queued; see TryDebugf.
+func (l *Logger) TryInfof(prefix, format string, v ...interface{}) error {
+	return l.log(Levels.Info, prefix, format, v, true, nil)
+}
+
+// TryWarnf logs a printf-style warn message like Warnf, returning an error
+// if it could not be queued; see TryDebugf.
+func (l *Logger) TryWarnf(prefix, format string, v ...interface{}) error {
+	return l.log(Levels.Warn, prefix, format, v, true, nil)
+}
+
+// TryErrorf logs a printf-style error message like Errorf, returning an
+// error if it could not be queued; see TryDebugf.
+func (l *Logger) TryErrorf(prefix, format string, v ...interface{}) error {
+	return l.log(Levels.Error, prefix, format, v, true, nil)
+}
+
+// TryPanicf logs a printf-style panic message like Panicf, returning an
+// error if it could not be queued instead of panicking; see TryDebugf.
+func (l *Logger) TryPanicf(prefix, format string, v ...interface{}) error {
+	return l.log(Levels.Panic, prefix, format, v, true, nil)
+}
+
+// SetPrefixFunc sets a function providing the prefix of messages logged
+// with an empty prefix, for prefixes only known when logging, such as the ID
+// of the device a request is for. It is called on the logging goroutine.
+func (l *Logger) SetPrefixFunc(fn func() string) {
+	l.prefixFunc = fn
+}
+
+// SetOutput writes the messages of this logger, and of loggers derived from
+// it afterwards, to w, one per line, in the same format as SetStdOut,
+// instead of the default output, level outputs or prefix routing. Other
+// loggers are unaffected, so a library or plugin can keep its logs apart
+// without changing the output of the host process. Sinks added with AddSink
+// still get every message. A nil w restores the default output. Call it
+// before the logger is shared. ReopenOutput, Flush and Close reach w like
+// the global outputs, for as long as the process runs, so prefer long-lived
+// writers.
+func (l *Logger) SetOutput(w io.Writer) {
+	if w == nil {
+		l.out = nil
+		return
+	}
+	addLoggerOutput(w)
+	l.out = writerSink{w}
 }
 
 func (l *Logger) SetLevel(level Level) {
@@ -172,41 +517,326 @@ func (l *Logger) Level() Level {
 	return l.level
 }
 
+// noLevelOverride is the value of levelOverride while loggers use their own
+// levels.
+const noLevelOverride = -2
+
+// levelOverride replaces the level of every logger unless it is
+// noLevelOverride; see SetGlobalLevelOverride
+var levelOverride int32 = noLevelOverride
+
+// SetGlobalLevelOverride makes every logger log at level, whatever its own
+// level, for instance to turn on debug messages during an incident without
+// restarting. ClearGlobalLevelOverride returns loggers to their own levels.
+func SetGlobalLevelOverride(level Level) {
+	atomic.StoreInt32(&levelOverride, int32(level))
+}
+
+// ClearGlobalLevelOverride returns every logger to its own level.
+func ClearGlobalLevelOverride() {
+	atomic.StoreInt32(&levelOverride, noLevelOverride)
+}
+
+// ToggleDebugOnSignal switches the global level override between Debug and
+// none whenever the process receives one of sigs, typically syscall.SIGUSR1.
+func ToggleDebugOnSignal(sigs ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		for range c {
+			if !atomic.CompareAndSwapInt32(&levelOverride, noLevelOverride, int32(Levels.Debug)) {
+				ClearGlobalLevelOverride()
+			}
+		}
+	}()
+}
+
+// effectiveLevel is the level of the logger, unless overridden globally.
+func (l *Logger) effectiveLevel() Level {
+	if level := atomic.LoadInt32(&levelOverride); level != noLevelOverride {
+		return Level(level)
+	}
+	if level := atomic.LoadInt32(&l.tempLevel); level != noLevelOverride {
+		return Level(level)
+	}
+	return l.level
+}
+
+// SetLevelFor makes the logger log at level for d, such as at debug for the
+// next ten minutes during an incident, and then go back to its own level.
+// Calling it again before d is up replaces the temporary level and starts d
+// over. The own level of the logger, as set with SetLevel and returned by
+// Level, is left alone, while SetGlobalLevelOverride still takes precedence.
+// Loggers derived from it don't get the temporary level. Nothing runs until
+// the revert is due, so a discarded logger leaves no goroutine behind.
+func (l *Logger) SetLevelFor(level Level, d time.Duration) {
+	if l == nil {
+		return
+	}
+
+	l.tempMu.Lock()
+	defer l.tempMu.Unlock()
+	if l.tempTimer != nil {
+		l.tempTimer.Stop()
+	}
+	atomic.StoreInt32(&l.tempLevel, int32(level))
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		l.tempMu.Lock()
+		defer l.tempMu.Unlock()
+		if l.tempTimer == timer { // not replaced by a later call
+			l.revertTemporaryLevel()
+		}
+	})
+	l.tempTimer = timer
+}
+
+// CancelTemporaryLevel returns the logger to its own level before the
+// duration given to SetLevelFor is up.
+func (l *Logger) CancelTemporaryLevel() {
+	if l == nil {
+		return
+	}
+
+	l.tempMu.Lock()
+	defer l.tempMu.Unlock()
+	if l.tempTimer != nil {
+		l.tempTimer.Stop()
+	}
+	l.revertTemporaryLevel()
+}
+
+// revertTemporaryLevel drops the temporary level; tempMu must be held.
+func (l *Logger) revertTemporaryLevel() {
+	atomic.StoreInt32(&l.tempLevel, noLevelOverride)
+	l.tempTimer = nil
+}
+
 func (l *Logger) SetAccessLogSample(sample uint64) {
 	atomic.StoreUint64(&l.sample, sample)
 }
 
+// annotateAccessSamples adds the sample rate to kept access logs; see
+// SetAccessSampleAnnotation
+var annotateAccessSamples bool
+
+// SetAccessSampleAnnotation adds fields to the access logs kept by
+// SetAccessLogSample, so that true rates can be worked out from the sample:
+// sampled with the rate, such as "1/100", and sample_dropped with the number
+// of access logs dropped since the previous one kept by the same logger.
+func SetAccessSampleAnnotation(enabled bool) {
+	annotateAccessSamples = enabled
+}
+
+// accessSampleFields adds the sampling fields of a kept access log to fields,
+// if SetAccessSampleAnnotation is on, and restarts the count of dropped ones.
+func (l *Logger) accessSampleFields(fields []Field) []Field {
+	dropped := atomic.SwapUint64(&l.sampleSkipped, 0)
+	sample := atomic.LoadUint64(&l.sample)
+	if !annotateAccessSamples || sample <= 1 {
+		return fields
+	}
+	return append([]Field{
+		{"sampled", "1/" + strconv.FormatUint(sample, 10)},
+		{"sample_dropped", dropped},
+	}, fields...)
+}
+
+// SetSampleRate keeps only every n-th message at level; a rate of 0 or 1
+// keeps all of them. Sampled out messages cost no more than messages below
+// the logger's level. For Levels.Access it is the same as SetAccessLogSample.
+func (l *Logger) SetSampleRate(level Level, n uint64) {
+	switch {
+	case level == Levels.Access:
+		l.SetAccessLogSample(n)
+		return
+	case level <= Levels.Off || level > Levels.Debug:
+		return
+	}
+
+	if l.sampling == nil {
+		if n <= 1 {
+			return
+		}
+		l.sampling = &levelSampling{}
+	}
+	atomic.StoreUint64(&l.sampling.rate[level], n)
+}
+
+// LevelPattern maps lines matching Pattern to Level in Logger.Write.
+type LevelPattern struct {
+	Level   Level
+	Pattern *regexp.Regexp
+}
+
+// stdLogLeader matches the date and time the standard log package may put
+// before a message.
+const stdLogLeader = `^(?:\d{4}/\d\d/\d\d )?(?:\d\d:\d\d:\d\d(?:\.\d+)? )?\s*`
+
+var (
+	// DefaultWriteLevelPatterns recognize a leading "[Error]", "level=error"
+	// or "Error" token, and the same for warnings, after an optional standard
+	// log date and time.
+	DefaultWriteLevelPatterns = []LevelPattern{
+		{Levels.Error, regexp.MustCompile(`(?i)` + stdLogLeader + `(?:\[error\]|level=error\b|error\b)`)},
+		{Levels.Warn, regexp.MustCompile(`(?i)` + stdLogLeader + `(?:\[warn(?:ing)?\]|level=warn(?:ing)?\b|warn(?:ing)?\b)`)},
+	}
+
+	writeLevelPatterns = DefaultWriteLevelPatterns
+)
+
+// SetWriteLevelPatterns sets how Logger.Write picks the level of a line: the
+// level of the first matching pattern is used, or Info if none match. Passing
+// nil restores DefaultWriteLevelPatterns. It should be called before logging.
+func SetWriteLevelPatterns(patterns []LevelPattern) {
+	if patterns == nil {
+		patterns = DefaultWriteLevelPatterns
+	}
+	writeLevelPatterns = patterns
+}
+
+// Write logs p, picking the level from a leading token such as "Error:" or
+// "[Warn]" (see SetWriteLevelPatterns). It is kept for existing users;
+// WriterAt logs at a fixed level instead, and is preferred.
 func (l *Logger) Write(p []byte) (int, error) {
 	level := Levels.Info
-	if bytes.Contains(p, []byte("Error")) {
-		level = Levels.Error
-	} else if bytes.Contains(p, []byte("Warn")) {
-		level = Levels.Warn
+	for _, lp := range writeLevelPatterns {
+		if lp.Pattern.Match(p) {
+			level = lp.Level
+			break
+		}
 	}
 	v := []interface{}{string(p)}
-	l.log(level, "", "%s", v, true)
+	l.log(level, "", "%s", v, true, nil)
 
 	return len(p), nil
 }
 
-func stripFile(file string) string {
-	paths := []string{
-		// Most to least specific
-		"vendor/github.com/kentik/",
-		"vendor/github.com/",
-		"vendor/",
-		"build/input/",
+// levelWriter is an io.Writer that logs every line written to it at a fixed
+// level and prefix.
+type levelWriter struct {
+	l      *Logger
+	level  Level
+	prefix string
+}
+
+// WriterAt returns an io.Writer that logs what is written to it at level with
+// prefix, one message per line. It suits plugging the logger into things like
+// http.Server.ErrorLog or exec.Cmd.Stderr.
+func (l *Logger) WriterAt(level Level, prefix string) io.Writer {
+	return &levelWriter{l, level, prefix}
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		w.l.log(w.level, w.prefix, "%s", []interface{}{string(line)}, true, nil)
 	}
+	return len(p), nil
+}
 
-	for _, s := range paths {
-		if idx := strings.Index(file, s); idx >= 0 {
-			file = file[idx+len(s):]
+// SetIncludeCaller turns the file and line of the logging call in messages
+// on or off. Looking them up is one of the costliest parts of logging, and is
+// skipped entirely when off. It is on by default.
+func SetIncludeCaller(enabled bool) {
+	includeCaller = enabled
+}
+
+// SetIncludeGoroutineID adds a goid field with the ID of the logging
+// goroutine to every message, to correlate messages when debugging
+// concurrency issues. It can also be turned on with KENTIK_LOG_GOID=1. Getting
+// the ID is not free, so it is off by default.
+func SetIncludeGoroutineID(enabled bool) {
+	includeGoroutineID = enabled
+}
+
+// goroutineID returns the ID of the current goroutine. There is no API for
+// it, so it is parsed from the "goroutine 1234 [running]:" stack header.
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+
+	var id uint64
+	for _, c := range b {
+		if c < '0' || c > '9' {
 			break
 		}
+		id = id*10 + uint64(c-'0')
+	}
+	return id
+}
+
+// defaultCallerPathPrefixes are stripped from caller files unless
+// SetCallerPathPrefixes says otherwise, most to least specific.
+var defaultCallerPathPrefixes = []string{
+	"vendor/github.com/kentik/",
+	"vendor/github.com/",
+	"vendor/",
+	"build/input/",
+}
+
+var (
+	// callerPathPrefixes are stripped from caller files; see
+	// SetCallerPathPrefixes
+	callerPathPrefixes = defaultCallerPathPrefixes
+
+	// callerBaseName logs only the base name of caller files; see
+	// SetCallerBaseName
+	callerBaseName bool
+
+	// callerFullPath logs caller files as runtime.Caller reports them; see
+	// SetCallerFullPath
+	callerFullPath bool
+)
+
+// SetCallerPathPrefixes sets the path prefixes stripped from the caller
+// files of messages. Everything up to and including the first prefix found
+// in a file is removed, so list them from most to least specific. A nil list
+// restores the defaults, which suit vendored kentik code, and an empty one
+// keeps the full paths. It is meant to be called at startup.
+func SetCallerPathPrefixes(prefixes []string) {
+	if prefixes == nil {
+		callerPathPrefixes = defaultCallerPathPrefixes
+		return
+	}
+	callerPathPrefixes = append([]string{}, prefixes...)
+}
+
+// SetCallerBaseName logs only the base name of caller files, such as
+// "logger.go", instead of their path. It is meant to be called at startup.
+func SetCallerBaseName(enabled bool) {
+	callerBaseName = enabled
+}
+
+// SetCallerFullPath logs the full path of caller files, as reported by
+// runtime.Caller, in every format, to tell apart files of the same name in
+// different modules. It takes precedence over SetCallerPathPrefixes and
+// SetCallerBaseName. It is meant to be called at startup.
+func SetCallerFullPath(enabled bool) {
+	callerFullPath = enabled
+}
+
+// stripFile shortens the file of a caller as set by SetCallerPathPrefixes and
+// SetCallerBaseName, unless SetCallerFullPath is on. It returns a substring
+// of file, so it doesn't allocate.
+func stripFile(file string) string {
+	if callerFullPath {
+		return file
+	}
+	if callerBaseName {
+		return file[strings.LastIndexByte(file, '/')+1:]
+	}
+	for _, s := range callerPathPrefixes {
+		if idx := strings.Index(file, s); idx >= 0 {
+			return file[idx+len(s):]
+		}
 	}
 	return file
 }
 
 func LogNoTee(level Level, prefix string, format string, v ...interface{}) {
-	New(Levels.Info).log(level, prefix, format, v, false)
+	New(Levels.Info).log(level, prefix, format, v, false, nil)
 }
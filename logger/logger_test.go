@@ -3,11 +3,37 @@ package logger
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"os"
 	"regexp"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+// blockingWriter blocks every write until release is closed.
+type blockingWriter struct {
+	bytes.Buffer
+	release chan struct{}
+	mu      sync.Mutex // guards Buffer, which tests may read while it is written
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Buffer.Write(p)
+}
+
+func (w *blockingWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Buffer.String()
+}
+
 // TestNilLogger tests that you can safely call log methods on a nil logger.
 // This is convenient, for example, when you'd like to test code without
 // creating and passing in a logger.
@@ -39,6 +65,23 @@ func TestRemoveNewline(t *testing.T) {
 	}
 }
 
+func TestSetTrimNewlines(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetTrimNewlines(true)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	SetTrimNewlines(false)
+	log := New(Levels.Debug)
+	log.Debugf("", "table")
+	log.Debugf("", "a | b\n1 | 2\n\n")
+	Drain()
+
+	if !regexp.MustCompile("^[^\n]*table\n[^\n]*a \\| b\n1 \\| 2\n\n$").Match(buf.Bytes()) {
+		t.Errorf("expected the trailing blank line to be kept but got %q", buf.String())
+	}
+}
+
 func TestClose(t *testing.T) {
 	defer func() { setup() }() // Set everything up again since we call Close()
 	buf := bytes.Buffer{}
@@ -65,3 +108,783 @@ func TestClose(t *testing.T) {
 		t.Fatalf("Expected log to panic, but it didn't.")
 	}
 }
+
+func TestConfigure(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer Configure(NumMessages)
+
+	Configure(4)
+	if cap(freeMessages) != 4 || len(freeMessages) != 4 {
+		t.Fatalf("expected a pool of 4 messages but got %d/%d", len(freeMessages), cap(freeMessages))
+	}
+
+	w := &blockingWriter{release: make(chan struct{})}
+	stdhdl = w
+
+	_, _, dropsBefore, _ := Stats()
+	log := New(Levels.Debug)
+	for i := 0; i < 10; i++ {
+		log.Infof("", "message %d", i)
+	}
+	close(w.release)
+	Drain()
+
+	if _, _, drops, _ := Stats(); drops-dropsBefore != 6 {
+		t.Errorf("expected 6 drops with a saturated pool of 4 but got %d", drops-dropsBefore)
+	}
+	if !regexp.MustCompile("^([^\n]*message [0-3]\n){4}$").Match(w.Bytes()) {
+		t.Errorf("expected the first 4 messages to be written but got %q", w.String())
+	}
+}
+
+func TestConfigureWhileLogging(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer Configure(NumMessages)
+	defer SetBlockOnFull(false)
+	stdhdl = io.Discard
+
+	for _, block := range []bool{false, true} {
+		SetBlockOnFull(block)
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				log := New(Levels.Info)
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						log.Infof("", "busy")
+						_ = log.LogBytes(Levels.Info, "", []byte("bytes"))
+					}
+				}
+			}()
+		}
+		for size := 1; size <= 8; size++ {
+			Configure(size)
+			if err := Flush(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		close(stop)
+		wg.Wait()
+	}
+	Drain()
+}
+
+func TestLoggerStats(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer Configure(NumMessages)
+
+	Configure(2)
+	w := &blockingWriter{release: make(chan struct{})}
+	stdhdl = w
+
+	busy, quiet := New(Levels.Debug), New(Levels.Debug)
+	for i := 0; i < 5; i++ {
+		busy.Infof("", "busy %d", i)
+	}
+	quiet.Debugf("", "quiet")
+	quiet.Debugf("", "quiet")
+	close(w.release)
+	Drain()
+
+	if logs, drop := busy.Stats(); logs != 5 || drop != 3 {
+		t.Errorf("expected 5 logs and 3 drops for the busy logger but got %d and %d", logs, drop)
+	}
+	if logs, drop := quiet.Stats(); logs != 2 || drop != 2 {
+		t.Errorf("expected 2 logs and 2 drops for the quiet logger but got %d and %d", logs, drop)
+	}
+}
+
+func TestLevelStats(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	stdhdl = io.Discard
+
+	before := LevelStats()
+	log := New(Levels.Info)
+	log.Errorf("", "error")
+	log.Errorf("", "error")
+	log.Infof("", "info")
+	log.Debugf("", "filtered out")
+	log.Access(AccessFields{Method: "GET", Path: "/", Status: 200})
+	Drain()
+
+	after := LevelStats()
+	for level, want := range map[Level]uint64{Levels.Error: 2, Levels.Info: 1, Levels.Access: 1, Levels.Debug: 0} {
+		if n := after[level] - before[level]; n != want {
+			t.Errorf("expected %d %s messages but got %d", want, level, n)
+		}
+	}
+}
+
+func TestWriterAt(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	w := New(Levels.Info).WriterAt(Levels.Warn, "[exec] ")
+	n, err := w.Write([]byte("No errors found\nsecond line\n"))
+	if err != nil || n != 28 {
+		t.Fatalf("expected 28 bytes written but got %d, %v", n, err)
+	}
+	Drain()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 messages but got %q", buf.String())
+	}
+	for i, want := range []string{"No errors found", "second line"} {
+		if !strings.Contains(lines[i], "[Warn] [exec] ") || !strings.HasSuffix(lines[i], want) {
+			t.Errorf("expected a Warn message %q but got %q", want, lines[i])
+		}
+	}
+}
+
+func TestWriteLevel(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetWriteLevelPatterns(nil)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	tests := []struct {
+		line  string
+		level string
+	}{
+		{"recovered from error", "[Info] "},
+		{"Error: boom", "[Error] "},
+		{"no warnings here", "[Info] "},
+		{"2021/05/04 03:02:01 Error: boom", "[Error] "},
+		{"[Warn] disk is getting full", "[Warn] "},
+		{"level=warning msg=slow", "[Warn] "},
+		{"Errors: 0", "[Info] "},
+	}
+
+	log := New(Levels.Debug)
+	for _, tt := range tests {
+		buf.Reset()
+		log.Write([]byte(tt.line))
+		Drain()
+		if !strings.Contains(buf.String(), tt.level) {
+			t.Errorf("expected %q to be logged at %s but got %q", tt.line, tt.level, buf.String())
+		}
+	}
+
+	SetWriteLevelPatterns([]LevelPattern{{Levels.Error, regexp.MustCompile("FATAL")}})
+	buf.Reset()
+	log.Write([]byte("Error: boom"))
+	log.Write([]byte("oops FATAL"))
+	Drain()
+	if lines := strings.Split(buf.String(), "\n"); !strings.Contains(lines[0], "[Info] ") || !strings.Contains(lines[1], "[Error] ") {
+		t.Errorf("expected custom patterns to be used but got %q", buf.String())
+	}
+}
+
+func TestIncludeGoroutineID(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetIncludeGoroutineID(false)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := New(Levels.Debug)
+	SetIncludeGoroutineID(true)
+	log.Infof("", "with goid")
+	done := make(chan uint64)
+	go func() {
+		log.Infof("", "with goid")
+		done <- goroutineID()
+	}()
+	other := <-done
+	SetIncludeGoroutineID(false)
+	log.Infof("", "without goid")
+	Drain()
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		got = append(got, line[strings.Index(line, "> ")+2:]) // strip everything up to the caller
+	}
+	want := []string{
+		fmt.Sprintf("with goid goid=%d", goroutineID()),
+		fmt.Sprintf("with goid goid=%d", other),
+		"without goid",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected %q but got %q", want, got)
+	}
+	if other == goroutineID() || other == 0 {
+		t.Errorf("expected different non-zero goroutine IDs but got %d and %d", goroutineID(), other)
+	}
+}
+
+func TestSetSampleRate(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := New(Levels.Debug)
+	log.SetSampleRate(Levels.Debug, 3)
+	for i := 1; i <= 9; i++ {
+		log.Debugf("", "debug %d", i)
+		log.Infof("", "info %d", i)
+	}
+	Drain()
+
+	if n := strings.Count(buf.String(), "[Debug] "); n != 3 {
+		t.Errorf("expected every 3rd of 9 debug messages but got %d", n)
+	}
+	if !strings.Contains(buf.String(), "debug 3\n") || strings.Contains(buf.String(), "debug 1\n") {
+		t.Errorf("expected debug 3 to be kept and debug 1 dropped but got %q", buf.String())
+	}
+	if n := strings.Count(buf.String(), "[Info] "); n != 9 {
+		t.Errorf("expected all 9 info messages but got %d", n)
+	}
+
+	buf.Reset()
+	log.SetSampleRate(Levels.Debug, 1)
+	log.Debugf("", "debug")
+	Drain()
+	if !strings.Contains(buf.String(), "debug") {
+		t.Error("expected sampling to be turned off by a rate of 1")
+	}
+}
+
+func BenchmarkSampledOut(b *testing.B) {
+	log := New(Levels.Debug)
+	log.SetSampleRate(Levels.Debug, uint64(b.N)+1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		log.Debugf("", "sampled out")
+	}
+}
+
+func BenchmarkDisabledDebugf(b *testing.B) {
+	log := New(Levels.Info)
+	flow, addr, n := "tcp", "10.0.0.1", 1500
+	b.Run("unguarded", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			log.Debugf("", "flow %s from %s: %d bytes", flow, addr, n)
+		}
+	})
+	b.Run("guarded", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if log.DebugEnabled() {
+				log.Debugf("", "flow %s from %s: %d bytes", flow, addr, n)
+			}
+		}
+	})
+}
+
+func TestLevelEnabled(t *testing.T) {
+	log := New(Levels.Warn)
+	if log.DebugEnabled() || log.InfoEnabled() || !log.WarnEnabled() || !log.ErrorEnabled() {
+		t.Error("expected only warn and error to be enabled at the warn level")
+	}
+	var nilLog *Logger
+	if nilLog.ErrorEnabled() {
+		t.Error("expected nothing to be enabled on a nil logger")
+	}
+}
+
+func TestSetBlockOnFull(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer Configure(NumMessages)
+	defer SetBlockOnFull(false)
+	Configure(2)
+
+	run := func(block bool) (drops uint64, written int) {
+		SetBlockOnFull(block)
+		w := &blockingWriter{release: make(chan struct{})}
+		stdhdl = w
+		_, _, dropsBefore, _ := Stats()
+
+		done := make(chan struct{})
+		go func() {
+			log := New(Levels.Debug)
+			for i := 0; i < 5; i++ {
+				log.Infof("", "message %d", i)
+			}
+			close(done)
+		}()
+		if block {
+			select {
+			case <-done:
+				t.Error("expected logging to block on a full pool")
+			case <-time.After(20 * time.Millisecond):
+			}
+		} else {
+			<-done
+		}
+		close(w.release)
+		<-done
+		Drain()
+
+		_, _, dropsAfter, _ := Stats()
+		return dropsAfter - dropsBefore, strings.Count(w.String(), "\n")
+	}
+
+	if drops, written := run(false); drops != 3 || written != 2 {
+		t.Errorf("expected 3 drops and 2 messages by default but got %d and %d", drops, written)
+	}
+	if drops, written := run(true); drops != 0 || written != 5 {
+		t.Errorf("expected no drops and 5 messages when blocking but got %d and %d", drops, written)
+	}
+}
+
+func TestSetTimeSource(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetTimeSource(nil)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	tm := time.Date(2021, 5, 4, 3, 2, 1, 987000000, time.Local)
+	SetTimeSource(func() time.Time { return tm })
+	New(Levels.Info).Infof("[test] ", "frozen")
+	Drain()
+
+	if want := tm.Format(STDOUT_FORMAT); !strings.HasPrefix(buf.String(), want) {
+		t.Errorf("expected the message stamped %q but got %q", want, buf.String())
+	}
+}
+
+func TestTryInfof(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer Configure(NumMessages)
+
+	Configure(1)
+	w := &blockingWriter{release: make(chan struct{})}
+	stdhdl = w
+
+	log := New(Levels.Info)
+	if err := log.TryInfof("", "first"); err != nil {
+		t.Errorf("expected the first message to be queued but got %v", err)
+	}
+	if err := log.TryDebugf("", "filtered"); err != nil {
+		t.Errorf("expected no error for a filtered message but got %v", err)
+	}
+	var err error
+	for i := 0; i < 3 && err == nil; i++ { // the writer may hold one message
+		err = log.TryInfof("", "second")
+	}
+	if err != ErrMessageDropped {
+		t.Errorf("expected %v with the pool exhausted but got %v", ErrMessageDropped, err)
+	}
+	close(w.release)
+	Drain()
+}
+
+func TestLevelFromEnv(t *testing.T) {
+	const key = "KENTIK_LOG_LEVEL_TEST"
+	defer os.Unsetenv(key)
+
+	tests := []struct {
+		env   string
+		level Level
+	}{
+		{"", Levels.Info},
+		{"debug", Levels.Debug},
+		{"WARN", Levels.Warn},
+		{" error ", Levels.Error},
+		{"verbose", Levels.Info},
+	}
+	for _, tt := range tests {
+		os.Setenv(key, tt.env)
+		if level := LevelFromEnv(key, Levels.Info); level != tt.level {
+			t.Errorf("%s=%q: expected %s but got %s", key, tt.env, tt.level, level)
+		}
+	}
+
+	os.Unsetenv(key)
+	if level := LevelFromEnv(key, Levels.Error); level != Levels.Error {
+		t.Errorf("expected the default when unset but got %s", level)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		s     string
+		level Level
+	}{
+		{"debug", Levels.Debug},
+		{" Info ", Levels.Info},
+		{"informational", Levels.Info},
+		{"WARNING", Levels.Warn},
+		{"err", Levels.Error},
+		{"fatal", Levels.Panic},
+		{"off", Levels.Off},
+		{"access", Levels.Access},
+	}
+	for _, tt := range tests {
+		if level, err := ParseLevel(tt.s); err != nil || level != tt.level {
+			t.Errorf("%q: expected %s but got %s, %v", tt.s, tt.level, level, err)
+		}
+	}
+
+	for _, s := range []string{"", "verbose", "warnings"} {
+		if _, err := ParseLevel(s); err == nil {
+			t.Errorf("%q: expected an error for an unknown level", s)
+		}
+	}
+}
+
+func TestSetLevelFor(t *testing.T) {
+	log := New(Levels.Info)
+	log.SetLevelFor(Levels.Debug, time.Hour)
+	if !log.DebugEnabled() || log.Level() != Levels.Info {
+		t.Errorf("expected debug messages while keeping the own level of Info")
+	}
+	if log.Named("child").DebugEnabled() {
+		t.Errorf("expected derived loggers not to get the temporary level")
+	}
+	log.CancelTemporaryLevel()
+	if log.DebugEnabled() {
+		t.Errorf("expected CancelTemporaryLevel to revert the level")
+	}
+
+	log.SetLevelFor(Levels.Debug, time.Hour)
+	log.SetLevelFor(Levels.Error, 10*time.Millisecond) // replaces the first
+	if log.InfoEnabled() {
+		t.Errorf("expected the second call to replace the temporary level")
+	}
+	deadline := time.Now().Add(time.Second)
+	for !log.InfoEnabled() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !log.InfoEnabled() || log.DebugEnabled() {
+		t.Errorf("expected the level to revert to Info once the duration is up")
+	}
+
+	var nilLog *Logger
+	nilLog.SetLevelFor(Levels.Debug, time.Hour)
+	nilLog.CancelTemporaryLevel()
+}
+
+func TestSetGlobalLevelOverride(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer ClearGlobalLevelOverride()
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := New(Levels.Info)
+	log.Debugf("", "hidden")
+	SetGlobalLevelOverride(Levels.Debug)
+	log.Debugf("", "overridden")
+	SetGlobalLevelOverride(Levels.Error)
+	log.Infof("", "quieted")
+	ClearGlobalLevelOverride()
+	log.Infof("", "restored")
+	log.Debugf("", "hidden again")
+	Drain()
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		got = append(got, line[strings.Index(line, "> ")+2:])
+	}
+	if want := []string{"overridden", "restored"}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected %q but got %q", want, got)
+	}
+}
+
+func TestSetIncludeCaller(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer func(f func(*logMessage) error, json bool) { format, sendJSON = f, json }(format, sendJSON)
+	defer SetIncludeCaller(true)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := New(Levels.Info)
+	SetIncludeCaller(false)
+	log.Infof("[x] ", "no caller")
+	Drain()
+	if !strings.HasSuffix(buf.String(), "[Info] [x] no caller\n") {
+		t.Errorf("expected no caller but got %q", buf.String())
+	}
+
+	buf.Reset()
+	format, sendJSON = asJSON, true
+	log.Infof("[x] ", "no caller")
+	Drain()
+	if strings.Contains(buf.String(), `"caller"`) {
+		t.Errorf("expected no caller but got %q", buf.String())
+	}
+}
+
+func TestWithCallerSkip(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := New(Levels.Info)
+	inner := log.WithCallerSkip(1)
+	logInfo := func(msg string) { inner.Infof("", msg) }
+	outer := inner.WithCallerSkip(1)
+	logOuter := func(msg string) { func() { outer.Infof("", msg) }() }
+
+	_, _, line, _ := runtime.Caller(0)
+	logInfo("one layer")
+	logOuter("two layers")
+	Drain()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for i, want := range []string{
+		fmt.Sprintf("logger_test.go: %d> one layer", line+1),
+		fmt.Sprintf("logger_test.go: %d> two layers", line+2),
+	} {
+		if i >= len(lines) || !strings.HasSuffix(lines[i], want) {
+			t.Errorf("expected a message ending in %q but got %q", want, buf.String())
+		}
+	}
+}
+
+func TestSetPrefixFunc(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	device := "dev1"
+	log := New(Levels.Info)
+	log.SetPrefixFunc(func() string { return "[" + device + "] " })
+	log.Infof("", "dynamic")
+	device = "dev2"
+	log.WithFields().Infof("", "inherited")
+	log.Infof("[static] ", "explicit")
+	Drain()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for i, want := range []string{"[Info] [dev1] ", "[Info] [dev2] ", "[Info] [static] "} {
+		if i >= len(lines) || !strings.Contains(lines[i], want) {
+			t.Errorf("expected message %d to contain %q but got %q", i, want, buf.String())
+		}
+	}
+}
+
+func TestSetAccessSampleAnnotation(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetAccessSampleAnnotation(false)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := New(Levels.Info)
+	log.SetAccessLogSample(3)
+	log.Printf(Levels.Access, "", "plain")
+	log.Printf(Levels.Access, "", "plain")
+	log.Printf(Levels.Access, "", "plain")
+	SetAccessSampleAnnotation(true)
+	for i := 0; i < 6; i++ {
+		log.Printf(Levels.Access, "", "annotated")
+	}
+	Drain()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], "plain") {
+		t.Fatalf("expected an unannotated message first but got %q", buf.String())
+	}
+	for _, line := range lines[1:] {
+		if !strings.HasSuffix(line, `annotated sampled=1/3 sample_dropped=2`) {
+			t.Errorf("expected an annotated message but got %q", line)
+		}
+	}
+}
+
+func TestCloseConcurrent(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetEmitLifecycle(false)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+	emitLifecycle = true
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if err := Close(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	emitLifecycle = false
+	Reinit()
+	Drain()
+
+	if n := strings.Count(buf.String(), "log closed"); n != 1 {
+		t.Errorf("expected the logger to be closed once but got %q", buf.String())
+	}
+}
+
+func TestReinit(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	Drain()
+	goroutines := runtime.NumGoroutine()
+	log := New(Levels.Info)
+	for i := 0; i < 3; i++ {
+		if err := Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := Close(context.Background()); err != nil {
+			t.Fatalf("expected closing twice to be fine but got %v", err)
+		}
+		Reinit()
+		Reinit() // no-op while running
+		log.Infof("", "cycle %d", i)
+		Drain()
+	}
+
+	if got := strings.Count(buf.String(), "cycle"); got != 3 {
+		t.Errorf("expected a message per cycle but got %q", buf.String())
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("expected no goroutine leak but went from %d to %d goroutines", goroutines, n)
+	}
+}
+
+func Test_stripFile(t *testing.T) {
+	defer SetCallerPathPrefixes(nil)
+	defer SetCallerBaseName(false)
+	defer SetCallerFullPath(false)
+
+	tests := []struct {
+		prefixes           []string
+		baseName, fullPath bool
+		file               string
+		want               string
+	}{
+		{nil, false, false, "/src/vendor/github.com/kentik/golog/logger/logger.go", "golog/logger/logger.go"},
+		{nil, false, false, "/home/me/src/app/main.go", "/home/me/src/app/main.go"},
+		{[]string{"/src/github.com/acme/", "/src/"}, false, false, "/home/me/src/github.com/acme/app/main.go", "app/main.go"},
+		{[]string{"/src/github.com/acme/", "/src/"}, false, false, "/home/me/src/other/main.go", "other/main.go"},
+		{[]string{}, false, false, "/src/vendor/github.com/x.go", "/src/vendor/github.com/x.go"},
+		{nil, true, false, "/src/vendor/github.com/kentik/golog/logger/logger.go", "logger.go"},
+		{nil, true, false, "main.go", "main.go"},
+		{nil, false, true, "/src/vendor/github.com/kentik/golog/logger/logger.go", "/src/vendor/github.com/kentik/golog/logger/logger.go"},
+		{nil, true, true, "/src/vendor/github.com/kentik/golog/logger/logger.go", "/src/vendor/github.com/kentik/golog/logger/logger.go"},
+	}
+	for _, tt := range tests {
+		SetCallerPathPrefixes(tt.prefixes)
+		SetCallerBaseName(tt.baseName)
+		SetCallerFullPath(tt.fullPath)
+		if got := stripFile(tt.file); got != tt.want {
+			t.Errorf("%q with prefixes %q, base name %v and full path %v: expected %q but got %q",
+				tt.file, tt.prefixes, tt.baseName, tt.fullPath, tt.want, got)
+		}
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { stripFile("/src/vendor/github.com/x.go") }); allocs != 0 {
+		t.Errorf("expected stripFile not to allocate but got %v allocations", allocs)
+	}
+}
+
+func TestNamed(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	root := New(Levels.Info).WithFields(Field{"service", "chf"})
+	chf := root.Named("[CHF]")
+	cloud := chf.Named("(netclass)").Named("(cloud)")
+	cloud.SetLevel(Levels.Debug)
+
+	cloud.Infof("[api] ", "request")
+	cloud.Debugf("", "no prefix")
+	chf.Debugf("", "filtered out")
+	root.Infof("[api] ", "unnamed")
+	Drain()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	want := []string{
+		"[Info] [CHF] (netclass) (cloud) [api] <",
+		"[Debug] [CHF] (netclass) (cloud) <",
+		"[Info] [api] <",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines but got %q", len(want), buf.String())
+	}
+	for i := range want {
+		if !strings.Contains(lines[i], want[i]) || !strings.HasSuffix(lines[i], "service=chf") {
+			t.Errorf("expected line %d to contain %q and the parent's fields but got %q", i, want[i], lines[i])
+		}
+	}
+
+	var nilLog *Logger
+	if nilLog.Named("x") != nil {
+		t.Error("expected a nil logger to stay nil")
+	}
+}
+
+func TestSetSyncLevel(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetSyncLevel(Levels.Off)
+	w := &blockingWriter{release: make(chan struct{})}
+	stdhdl = w
+
+	SetSyncLevel(Levels.Error)
+	log := New(Levels.Info)
+
+	logged := make(chan struct{})
+	go func() {
+		log.Errorf("", "critical")
+		close(logged)
+	}()
+	select {
+	case <-logged:
+		t.Fatal("expected Errorf to wait for the write")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	log.Infof("", "async") // doesn't wait for the blocked writer
+	log.SetAccessLogSample(1)
+	accessLogged := make(chan struct{})
+	go func() {
+		log.Printf(Levels.Access, "", "access")
+		close(accessLogged)
+	}()
+	select {
+	case <-accessLogged:
+	case <-time.After(time.Second):
+		t.Error("expected access logs not to wait for the write")
+	}
+	close(w.release)
+	<-logged
+	if !strings.Contains(w.String(), "critical") {
+		t.Errorf("expected the error to be written when Errorf returns but got %q", w.String())
+	}
+	Drain()
+}
+
+func TestLoggerSetOutput(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	global, own := bytes.Buffer{}, bytes.Buffer{}
+	stdhdl = &global
+
+	plugin := New(Levels.Info)
+	plugin.SetOutput(&own)
+	plugin.Infof("[plugin] ", "own output")
+	plugin.WithFields(Field{"k", "v"}).Infof("[plugin] ", "derived")
+	New(Levels.Info).Infof("[host] ", "default output")
+	Drain()
+
+	if !strings.Contains(own.String(), "own output\n") || !strings.Contains(own.String(), "derived k=v\n") || strings.Contains(own.String(), "[host]") {
+		t.Errorf("expected the plugin messages in its own output but got %q", own.String())
+	}
+	if !strings.Contains(global.String(), "default output\n") || strings.Contains(global.String(), "[plugin]") {
+		t.Errorf("expected only the host message in the default output but got %q", global.String())
+	}
+
+	plugin.SetOutput(nil)
+	plugin.Infof("[plugin] ", "restored")
+	Drain()
+	if !strings.Contains(global.String(), "restored\n") {
+		t.Errorf("expected a nil output to restore the default output but got %q", global.String())
+	}
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package logger
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var toggleOnce sync.Once
+
+func TestToggleDebugOnSignal(t *testing.T) {
+	defer ClearGlobalLevelOverride()
+	toggleOnce.Do(func() { ToggleDebugOnSignal(syscall.SIGUSR1) }) // once for -count
+
+	log := New(Levels.Info)
+	for _, want := range []bool{true, false} {
+		syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+		deadline := time.Now().Add(time.Second)
+		for log.levelEnabled(Levels.Debug) != want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if log.levelEnabled(Levels.Debug) != want {
+			t.Fatalf("expected debug enabled to be %v after the signal", want)
+		}
+	}
+}
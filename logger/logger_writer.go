@@ -3,92 +3,153 @@ package logger
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
-	"unsafe"
+	"unicode/utf8"
 )
 
-// The csyslog function is necessary here because cgo does not appear
-// to be able to call a variadic function directly and syslog has the
-// same signature as printf.
-
-// #include <stdlib.h>
-// #ifdef _WIN32               /* for Windows builds, syslog functions do NOTHING */
-// void csyslog(int p, const char *m) {}
-// void openlog(const char *m, int i, int l) {}
-// #define	LOG_ERR		3      /* error conditions */
-// #define	LOG_WARNING	4      /* warning conditions */
-// #define	LOG_INFO	6      /* informational */
-// #define	LOG_DEBUG	7      /* debug-level messages */
-// #define	LOG_PID		0x01   /* log the pid with each message */
-// #define	LOG_NDELAY	0x08   /* don't delay open */
-// #define	LOG_NOWAIT	0x10   /* don't wait for console forks: DEPRECATED */
-// #define	LOG_USER	(1<<3) /* random user-level messages */
-// #else
-// #include <syslog.h>
-// void csyslog(int p, const char *m) {
-//     syslog(p, "%s", m);
-// }
-// #endif
-import "C"
-
 const (
 	NumMessages   = 10 * 1024 // number of allowed log messages
 	STDOUT_FORMAT = "2006-01-02T15:04:05.000 "
+
+	// bounds on how long to wait between attempts to reconnect the custom socket
+	minRedialBackoff = 100 * time.Millisecond
+	maxRedialBackoff = 30 * time.Second
+	redialTimeout    = time.Second
+
+	truncatedMarker = "…[truncated %d bytes]" // follows messages cut by SetMaxMessageBytes
+
+	syncWriteTimeout = 5 * time.Second // how long SetSyncLevel waits for a write at most
 )
 
 // logMessage contains a pending log message
 type logMessage struct {
 	bytes.Buffer
-	level C.int
+	level int // syslog severity
 	time  time.Time
+	le    logEntry
+	meta  []Field // fields added by the logger itself, such as mono_ns
+
+	// control is set on the sentinel messages of Flush and ReopenOutput,
+	// which the writer goroutine runs instead of writing
+	control func()
+
+	// written is closed once the message has been written or discarded, for
+	// messages logged at the sync level; see SetSyncLevel
+	written chan struct{}
+
+	// spare is set on the messages of LogBytes, which go back to
+	// bytesMessages instead of the fixed set; raw holds their message text
+	spare bool
+	raw   []byte
 }
 
 // logCaller stores where the logger public log method was called
 type logCaller struct {
-	file string
-	line int
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// String returns the caller in the flat "file:line" form.
+func (lc logCaller) String() string {
+	return fmt.Sprintf("%s:%d", lc.File, lc.Line)
+}
+
+// logEntryStructured is the shape of a message when logging as JSON
+type logEntryStructured struct {
+	Time    jsonTime    `json:"time"`
+	Name    string      `json:"name"`
+	Level   string      `json:"level"`
+	Prefix  string      `json:"prefix"`
+	Caller  interface{} `json:"caller,omitempty"` // logCaller, or a "file:line" string when callerAsString is set
+	Message string      `json:"message"`
 }
 
 // logEntry encapsulates all parameters to queueMsg
 type logEntry struct {
-	lvl  Level
-	pre  string
-	fmt  string
-	fmtV []interface{}
-	lc   logCaller
-	tee  bool
+	lvl    Level
+	pre    string
+	fmt    string
+	fmtV   []interface{}
+	lc     logCaller
+	tee    bool
+	fields []Field
+	goid   uint64 // zero unless SetIncludeGoroutineID is on
+	raw    []byte // the message text of LogBytes, used instead of fmt
+	out    sink   // the output of the logger, or nil; see (*Logger).SetOutput
+}
+
+// text returns the message text of the entry.
+func (le *logEntry) text() string {
+	if le.raw != nil {
+		return string(le.raw)
+	}
+	return fmt.Sprintf(le.fmt, le.fmtV...)
 }
 
 var (
 	ErrLogFullBuf           = errors.New("Log message queue is full")
 	ErrFreeMessageOverflow  = errors.New("Too many free messages. Overflow of fixed	set.")
 	ErrFreeMessageUnderflow = errors.New("Too few free messages. Underflow of fixed	set.")
+	ErrCustomSocketDown     = errors.New("Custom socket is down, waiting to reconnect")
+	ErrTeeFull              = errors.New("Log tee is full")
+	ErrMessageDropped       = errors.New("Log message dropped, no free messages")
+	ErrNotStreamNetwork     = errors.New("TLS needs a stream network, such as tcp")
+	ErrNotStreamOutput      = errors.New("Compression needs a file, pipe or stream socket output")
+	ErrLogRecursion         = errors.New("Logged from the log writer, written to stderr")
+	ErrLoggerClosed         = errors.New("Logger is closed, call Reinit")
 
 	// the logName object for syslog to use
-	logName       *C.char
 	logNameString string
 
+	// see SetIncludePID and SetIncludeHostname; pid is looked up once, at
+	// startup, like hostname
+	includePID      bool
+	includeHostname bool
+	pid             = os.Getpid()
+
 	// the message queue of pending or free messages
 	// since only one can be full at a time, the total size will be about 10MB
 	messages     chan *logMessage
 	freeMessages chan *logMessage
 
+	// poolSize is the number of messages in the fixed set; see Configure
+	poolSize = NumMessages
+
+	// see SetIncludeDelta; lastWriteTime is only used by the writer goroutine
+	includeDelta  bool
+	lastWriteTime time.Time
+
+	// see SetIncludeSequence; writeSeq is only used by the writer goroutine
+	includeSequence bool
+	writeSeq        uint64
+
+	blockOnFull int32 // atomic; see SetBlockOnFull
+
+	// see SetWriteRetries
+	writeRetries      int
+	writeRetryBackoff time.Duration
+
 	// mapping of our levels to syslog values
-	levelSysLog = map[Level]C.int{
-		Levels.Access: C.LOG_INFO,
-		Levels.Off:    C.LOG_DEBUG,
-		Levels.Panic:  C.LOG_ERR,
-		Levels.Error:  C.LOG_ERR,
-		Levels.Warn:   C.LOG_WARNING,
-		Levels.Info:   C.LOG_INFO,
-		Levels.Debug:  C.LOG_DEBUG,
+	levelSysLog = map[Level]int{
+		Levels.Access: logInfo,
+		Levels.Off:    logDebug,
+		Levels.Panic:  logErr,
+		Levels.Error:  logErr,
+		Levels.Warn:   logWarning,
+		Levels.Info:   logInfo,
+		Levels.Debug:  logDebug,
 	}
 
 	// mirror of levelMap used to avoid making a new string with '[]' on every log
@@ -103,22 +164,218 @@ var (
 		Levels.Debug:  []byte("[Debug] "),
 	}
 
+	// compact single character levels, as in glog; see SetCompactLevels
+	levelMapCompact = map[Level][]byte{
+		Levels.Access: []byte("A "),
+		Levels.Off:    []byte("O "),
+		Levels.Panic:  []byte("P "),
+		Levels.Error:  []byte("E "),
+		Levels.Warn:   []byte("W "),
+		Levels.Info:   []byte("I "),
+		Levels.Debug:  []byte("D "),
+	}
+	compactLevels bool
+
 	customSock net.Conn = nil
 
+	// dial parameters and state used to reconnect the custom socket
+	customSockAddress, customSockNetwork string
+	customSockConnected                  int32 // atomic; 1 while customSock is usable
+	customSockTLS                        *tls.Config
+	redialBackoff                        time.Duration
+	redialAt                             time.Time
+
 	logWriterFinished chan struct{}
+	writerStopped     int32 // atomic; 1 once messages is closed
+
+	// writerMu serializes Close, Configure and Reinit, so that the writer
+	// goroutine is stopped and started once each time
+	writerMu sync.Mutex
+
+	// queueMu guards closing and replacing the message channels: goroutines
+	// hold it for reading while they queue a message, so that they never
+	// send on a closed channel
+	queueMu sync.RWMutex
+
+	// stopping is closed along with messages, waking up the goroutines
+	// waiting for a message of the pool; writerClosed is set when Close
+	// stopped the writer, rather than Configure. Both are guarded by queueMu.
+	stopping     chan struct{}
+	writerClosed bool
 
 	stdhdl io.Writer
 
-	logTee chan string
+	// tees holds the current *teeSet, which is replaced rather than
+	// modified, so that logging goroutines and the writer can read it while
+	// tees are set; teesMu serializes the changes
+	tees       atomic.Value
+	teesMu     sync.Mutex
+	teeTimeout time.Duration
+	teeLevel   = Levels.Debug // see SetTeeLevel
+
+	// redactor rewrites messages before they are written; see SetRedactor
+	redactor func([]byte) []byte
+
+	// format renders a log entry into the message buffer; see setFormat
+	format = asString
+
+	// formatName is the name of format, as in KENTIK_LOG_FMT
+	formatName = "string"
+
+	// sendJSON is set when messages are rendered as JSON objects, including
+	// GELF
+	sendJSON bool
+
+	// cefFormat is set when messages are rendered as CEF
+	cefFormat bool
+
+	// jsonFieldPrefix comes before the keys of fields in JSON objects
+	jsonFieldPrefix string
+
+	// callerAsString keeps the legacy flat "file:line" caller in JSON output
+	callerAsString bool
+
+	// trimNewlines strips trailing newlines from messages; see SetTrimNewlines
+	trimNewlines = true
+
+	// jsonFieldNames renames the standard JSON keys; see SetJSONFieldNames
+	jsonFieldNames map[string]string
+
+	// monotonicTimestamps adds a mono_ns field; see SetMonotonicTimestamps
+	monotonicTimestamps bool
+	processStart        = time.Now()
+
+	// nowFn is the clock messages are stamped with; see SetTimeSource
+	nowFn = time.Now
+
+	// maxMessageBytes limits the size of rendered messages when positive
+	maxMessageBytes int
+
+	// strictFormat replaces messages with bad format verbs; see
+	// SetStrictFormat
+	strictFormat bool
+
+	// deferredRendering formats messages on the writer goroutine
+	deferredRendering bool
+
+	// syncLevel is the least severe level logging waits for; see SetSyncLevel
+	syncLevel = Levels.Off
 )
 
+// messageFormat is a message format that can be selected with KENTIK_LOG_FMT.
+type messageFormat struct {
+	render          func(*logMessage) error
+	sendJSON        bool
+	jsonFieldPrefix string
+}
+
+// messageFormats holds the formats by their KENTIK_LOG_FMT name; any other
+// name selects "string".
+var messageFormats = map[string]messageFormat{
+	"string": {asString, false, ""},
+	"json":   {asJSON, true, ""},
+	"gelf":   {asGELF, true, "_"},
+	"ecs":    {asECS, true, ""},
+	"cef":    {asCEF, false, ""},
+}
+
+// setFormat selects the message format from the environment. Setting
+// KENTIK_LOG_FMT=json renders every message as a JSON object,
+// KENTIK_LOG_FMT=gelf as a GELF 1.1 object for Graylog,
+// KENTIK_LOG_FMT=ecs as an Elastic Common Schema object, and
+// KENTIK_LOG_FMT=cef in the Common Event Format for ArcSight.
+// KENTIK_LOG_CALLER=string keeps the JSON caller in the old "file:line" form.
+// It also reads the color conventions; see setColorEnv.
+func setFormat() {
+	callerAsString = strings.ToLower(os.Getenv("KENTIK_LOG_CALLER")) == "string"
+	setColorEnv()
+	setFormatName(os.Getenv("KENTIK_LOG_FMT"))
+}
+
+// setFormatName selects the message format by its KENTIK_LOG_FMT name.
+func setFormatName(name string) {
+	formatName = strings.ToLower(name)
+	f, ok := messageFormats[formatName]
+	if !ok {
+		formatName = "string"
+		f = messageFormats[formatName]
+	}
+	format, sendJSON, jsonFieldPrefix = f.render, f.sendJSON, f.jsonFieldPrefix
+	cefFormat = formatName == "cef"
+}
+
+// SetBlockOnFull makes logging wait for a free message when all of them are
+// in use, instead of dropping the message. Use it when losing messages is
+// worse than stalling, such as for audit logs. If the writer goroutine gets
+// stuck, for instance on a blocking output, every goroutine that logs will
+// block with it. It applies to the whole process.
+func SetBlockOnFull(block bool) {
+	var v int32
+	if block {
+		v = 1
+	}
+	atomic.StoreInt32(&blockOnFull, v)
+}
+
+// SetTimeSource sets the clock messages are stamped with, so tests can
+// freeze it and expect exact timestamps. A nil now restores time.Now.
+func SetTimeSource(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	nowFn = now
+}
+
+// SetMonotonicTimestamps adds a mono_ns field to every message: the
+// nanoseconds since the logger started, read from the monotonic clock. Unlike
+// the wall clock time it never jumps, so it suits measuring the interval
+// between messages, for instance in benchmarks. It is meaningless across
+// process restarts.
+func SetMonotonicTimestamps(enabled bool) {
+	monotonicTimestamps = enabled
+}
+
 // SetCustomSocket will switch over to writing log messages to the defined socket.
+// If a write to the socket fails, it is closed and dialed again, backing off
+// while the remote end stays down.
 func SetCustomSocket(address, network string) (err error) {
 	customSock, err = net.Dial(network, address)
+	if err == nil {
+		customSockAddress, customSockNetwork, customSockTLS = address, network, nil
+		redialBackoff, redialAt = 0, time.Time{}
+		atomic.StoreInt32(&customSockConnected, 1)
+	}
+
+	return err
+}
+
+// SetCustomSocketTLS is SetCustomSocket over TLS. The network must be a
+// stream network, such as tcp. A nil cfg verifies the server certificate
+// against the system roots.
+func SetCustomSocketTLS(address, network string, cfg *tls.Config) (err error) {
+	if !streamNetwork(network) {
+		return ErrNotStreamNetwork
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+
+	customSock, err = tls.Dial(network, address, cfg)
+	if err == nil {
+		customSockAddress, customSockNetwork, customSockTLS = address, network, cfg
+		redialBackoff, redialAt = 0, time.Time{}
+		atomic.StoreInt32(&customSockConnected, 1)
+	}
 
 	return err
 }
 
+// CustomSocketConnected reports whether the custom socket is currently
+// connected. It is false while the socket is waiting to be reconnected.
+func CustomSocketConnected() bool {
+	return atomic.LoadInt32(&customSockConnected) == 1
+}
+
 func SetStdOut() {
 	stdhdl = io.Writer(os.Stdout)
 }
@@ -127,30 +384,217 @@ func SetStdErr() {
 	stdhdl = io.Writer(os.Stderr)
 }
 
-func SetTee(tee chan string) {
-	logTee = tee
+// SetWriter writes messages to w, one per line, in the same format as
+// SetStdOut. Use it with a RotatingFileWriter to log to a file.
+func SetWriter(w io.Writer) {
+	stdhdl = w
+}
+
+// SetRedactor sets a function that rewrites every rendered message before
+// it is written, such as to mask credentials that were logged by accident.
+// It gets the message without the C null terminator or any framing, and may
+// modify it in place or return a new slice. It runs on the writer goroutine,
+// off the logging path, and also on the lines sent to the tees. A nil fn,
+// the default, writes messages as they are.
+func SetRedactor(fn func([]byte) []byte) {
+	redactor = fn
+}
+
+// redact applies the redactor to a rendered message, keeping the C null
+// terminator at the end.
+func redact(msg *logMessage) {
+	out := redactor(msg.Bytes()[:msg.Len()-1])
+	msg.Truncate(0)
+	msg.Write(out) // copy handles out overlapping the buffer
+	msg.WriteByte(0)
+}
+
+// SetTeeLevel limits the tees to messages at level or a more severe one,
+// while the outputs get every message the logger logs. For example, the tee
+// of a live debugging UI can carry just warnings and errors while stdout
+// also gets info messages. Access messages are teed at any level. The
+// default of Levels.Debug tees everything.
+func SetTeeLevel(level Level) {
+	teeLevel = level
+}
+
+// teeMsg reports whether the message should be sent to the tees.
+func teeMsg(le *logEntry) bool {
+	return len(loadTees().str) > 0 && teeable(le)
+}
+
+// teeable reports whether the message passes the tee level.
+func teeable(le *logEntry) bool {
+	return le.tee && le.lvl <= teeLevel
+}
+
+// tee is a channel that gets a copy of every teed message, with the number
+// of messages it missed because it was full.
+type tee struct {
+	ch    chan string
+	drops uint64 // atomic
+}
+
+// teeSet is the string and entry tees messages are sent to.
+type teeSet struct {
+	str     []*tee
+	entries []*entryTee // see AddEntryTee
+}
+
+var noTees teeSet
+
+// loadTees returns the current tees, which must not be modified.
+func loadTees() *teeSet {
+	if ts, ok := tees.Load().(*teeSet); ok {
+		return ts
+	}
+	return &noTees
+}
+
+// updateTees replaces the tees with a copy changed by f.
+func updateTees(f func(ts *teeSet)) {
+	teesMu.Lock()
+	defer teesMu.Unlock()
+
+	cur := loadTees()
+	next := &teeSet{
+		str:     append([]*tee(nil), cur.str...),
+		entries: append([]*entryTee(nil), cur.entries...),
+	}
+	f(next)
+	tees.Store(next)
+}
+
+// SetTee sends a copy of each message to the channel, replacing any tees
+// added before, including entry tees. A nil channel turns teeing off.
+func SetTee(ch chan string) {
+	updateTees(func(ts *teeSet) {
+		ts.str, ts.entries = nil, nil
+		if ch != nil {
+			ts.str = []*tee{{ch: ch}}
+		}
+	})
+}
+
+// AddTee sends a copy of each message to the channel as well as to the tees
+// already set. Each tee is written without blocking and drops messages on its
+// own when full, see TeeDropsFor.
+func AddTee(ch chan string) {
+	updateTees(func(ts *teeSet) { ts.str = append(ts.str, &tee{ch: ch}) })
+}
+
+// TeeEntry is a teed message along with the sequence number it was written
+// with, for consumers that read both a tee and the main output and need to
+// put them back in order.
+type TeeEntry struct {
+	Seq    uint64 // matches the seq field of the main output; see SetIncludeSequence
+	Time   time.Time
+	Level  Level
+	Prefix string
+	Line   string // the message as sent to string tees
+}
+
+// entryTee is a channel of TeeEntry, with the number of entries it missed
+// because it was full.
+type entryTee struct {
+	ch    chan TeeEntry
+	drops uint64 // atomic
+}
+
+// AddEntryTee sends a TeeEntry for each message to the channel. Unlike string
+// tees, which get messages as they are logged, entry tees are sent to by the
+// writer goroutine just before the message is written, so entries arrive in
+// output order and their Seq matches the seq field of the main output. A full
+// entry tee drops entries like other tees, though with a tee timeout set it
+// holds up the writer while it waits. SetTee(nil) removes entry tees.
+func AddEntryTee(ch chan TeeEntry) {
+	updateTees(func(ts *teeSet) { ts.entries = append(ts.entries, &entryTee{ch: ch}) })
+}
+
+// SetTeeTimeout sets how long logging waits for room in a full tee before
+// dropping the message. The default of zero drops immediately.
+func SetTeeTimeout(d time.Duration) {
+	teeTimeout = d
+}
+
+// OutputMode declares up front where messages will be written; see
+// SetOutputMode.
+type OutputMode int
+
+const (
+	// ModeUnset decides by the output selected when SetLogName is called.
+	ModeUnset OutputMode = iota
+	ModeStdout
+	ModeSyslog
+	ModeSocket
+)
+
+var (
+	outputMode OutputMode
+
+	// openSyslogFn opens the local syslog; tests replace it
+	openSyslogFn = openSyslog
+)
+
+// SetOutputMode declares which kind of output the program will log to, so
+// that SetLogName only opens the local syslog with ModeSyslog, whichever
+// output setter is called first. Without it, SetLogName opens syslog unless
+// SetStdOut, SetStdErr or SetWriter was called before it. The mode doesn't
+// select the output itself. Call it before SetLogName.
+func SetOutputMode(m OutputMode) {
+	outputMode = m
 }
 
 // SetLogName sets the identifier used by syslog for this program
 func SetLogName(p string) (err error) {
 
 	logNameString = p
-	if stdhdl != nil {
+	switch outputMode {
+	case ModeUnset:
+		if stdhdl != nil {
+			return
+		}
+	case ModeSyslog:
+	default:
 		return
 	}
 
-	if logName != nil {
-		C.free(unsafe.Pointer(logName))
-	}
-	logName = C.CString(p)
-	_, err = C.openlog(logName, C.LOG_NDELAY|C.LOG_NOWAIT|C.LOG_PID, C.LOG_USER)
-	if err != nil {
+	if err = openSyslogFn(p); err != nil {
 		atomic.AddUint64(&errCount, 1)
 	}
 
 	return err
 }
 
+// SetCompactLevels writes levels in string messages as a single character,
+// such as "I " for info or "E " for error, instead of "[Info] ", as glog
+// does, to save room in high volume logs. JSON and other structured formats
+// are unaffected. It is off by default.
+func SetCompactLevels(enabled bool) {
+	compactLevels = enabled
+}
+
+// levelFmt returns the level as written in string messages.
+func levelFmt(level Level) []byte {
+	if compactLevels {
+		return levelMapCompact[level]
+	}
+	return levelMapFmt[level]
+}
+
+// SetIncludePID adds the process ID to the leader of stdout messages, as in
+// "name[pid]: ", after the log name. It is off by default.
+func SetIncludePID(enabled bool) {
+	includePID = enabled
+}
+
+// SetIncludeHostname adds the host name to the leader of stdout messages,
+// after the time. It is off by default. The host name is looked up once,
+// at startup.
+func SetIncludeHostname(enabled bool) {
+	includeHostname = enabled
+}
+
 const reclaimThreshold int = 5120 // Seems to be around p99 on our runner-master log messages
 
 // freeMsg releases the message back to be reused
@@ -160,8 +604,23 @@ func freeMsg(msg *logMessage) (err error) {
 	} else {
 		msg.Reset()
 	}
+	msg.le = logEntry{} // don't hold on to the format arguments
+	msg.meta = msg.meta[:0]
+	msg.control = nil
+	if msg.written != nil {
+		close(msg.written)
+		msg.written = nil
+	}
+	if msg.spare {
+		freeBytesMsg(msg)
+		return
+	}
+	atomic.AddUint64(&freedCount, 1)
 	select {
-	case freeMessages <- msg: // no-op
+	case freeMessages <- msg:
+		if len(freeMessages) >= int(atomic.LoadInt32(&poolFilled)) {
+			notifyDrained()
+		}
 	default:
 		atomic.AddUint64(&errCount, 1)
 		return ErrFreeMessageOverflow
@@ -170,155 +629,1018 @@ func freeMsg(msg *logMessage) (err error) {
 	return
 }
 
+// poolFilled is the number of messages the pool was filled with, normally
+// poolSize; see CheckPool
+var poolFilled int32
+
+// freedCount is bumped before a message goes back to the pool, so that
+// DrainContext, reading it once the pool is full, is ordered after the
+// writes of every freed message; atomic
+var freedCount uint64
+
+// drainMu guards drained, which is closed when every message is back in
+// the pool, waking up DrainContext. It is created by the first waiter.
+var (
+	drainMu sync.Mutex
+	drained chan struct{}
+)
+
+// drainSignal returns a channel closed the next time every message is back
+// in the pool.
+func drainSignal() chan struct{} {
+	drainMu.Lock()
+	defer drainMu.Unlock()
+	if drained == nil {
+		drained = make(chan struct{})
+	}
+	return drained
+}
+
+// notifyDrained wakes up the goroutines waiting in DrainContext.
+func notifyDrained() {
+	drainMu.Lock()
+	if drained != nil {
+		close(drained)
+		drained = nil
+	}
+	drainMu.Unlock()
+}
+
 // queueMsg adds a message to the pending messages channel. It will drop the
 // message and return an error if the channel is full.
 func queueMsg(le *logEntry) (err error) {
-	atomic.AddUint64(&logCount, 1)
-	var msg *logMessage
+	countLog(le.lvl)
+	if inWriter() {
+		_ = writeRecursive(le)
+		return ErrLogRecursion
+	}
 
-	// get a message if possible
-	select {
-	case msg = <-freeMessages: // got a message-struct; proceed
-	default:
-		// no messages left, drop
-		atomic.AddUint64(&dropCount, 1)
-		return
+	pool, stop, err := currentPool()
+	if err == ErrLoggerClosed {
+		panic(err) // see Close
 	}
 
-	msg.time = time.Now()
+	// get a message if possible
+	var msg *logMessage
+	if err == nil {
+		if atomic.LoadInt32(&blockOnFull) == 1 {
+			select {
+			case msg = <-pool:
+			case <-stop:
+			}
+		} else {
+			select {
+			case msg = <-pool: // got a message-struct; proceed
+			default:
+			}
+		}
+	}
+	if msg == nil {
+		// no messages left, or the pool is being replaced: drop
+		countDrop(le)
+		return ErrMessageDropped
+	}
 
-	onErr := func() {
-		atomic.AddUint64(&errCount, 1)
-		_ = freeMsg(msg) // ignore error
+	msg.le = *le
+	var written chan struct{}
+	if le.lvl > Levels.Off && le.lvl <= syncLevel { // not Access
+		written = make(chan struct{})
+		msg.written = written
 	}
+	if deferredRendering {
+		stamp(msg) // the writer renders and tees it
+	} else {
+		if err = render(msg); err != nil {
+			atomic.AddUint64(&errCount, 1)
+			releaseMsg(msg, pool)
+			return
+		}
 
-	lvl, prefix, format, v := le.lvl, le.pre, le.fmt, le.fmtV
-	// render the message: level prefix, message body, C null terminator
-	msg.level = levelSysLog[lvl]
-	file, line := le.lc.file, le.lc.line
-	if _, err = msg.Write(levelMapFmt[lvl]); err != nil {
-		onErr()
-		return
+		// tee the message before 'logWriter' calls 'freeMsg'
+		if teeMsg(le) {
+			_ = writeTee(msg) // counted in teeDropCount
+		}
 	}
-	if _, err = fmt.Fprintf(msg, "%s", prefix); err != nil {
-		onErr()
+
+	if err = sendMsg(msg, pool); err != nil {
+		if err == ErrMessageDropped {
+			countDrop(le)
+		}
 		return
 	}
-	if _, err = fmt.Fprintf(msg, "<%s: %d> ", file, line); err != nil {
-		onErr()
-		return
+
+	if written != nil {
+		waitWritten(written)
 	}
-	if _, err = fmt.Fprintf(msg, format, v...); err != nil {
-		onErr()
-		return
+	return
+}
+
+// countDrop counts a dropped message and reports it to the drop hook.
+func countDrop(le *logEntry) {
+	atomic.AddUint64(&dropCount, 1)
+	if dropHook != nil {
+		dropHook(le.lvl, le.pre, le.fmt)
 	}
-	if err = msg.WriteByte(0); err != nil {
-		onErr()
-		return
+}
+
+// currentPool returns the message pool to take messages from, and the
+// channel closed when the writer stops. It returns ErrLoggerClosed after
+// Close, and ErrMessageDropped while Configure replaces the pool.
+func currentPool() (chan *logMessage, chan struct{}, error) {
+	queueMu.RLock()
+	defer queueMu.RUnlock()
+	if atomic.LoadInt32(&writerStopped) == 1 {
+		if writerClosed {
+			return nil, nil, ErrLoggerClosed
+		}
+		return nil, nil, ErrMessageDropped
 	}
+	return freeMessages, stopping, nil
+}
 
-	// tee the message before 'logWriter' calls 'freeMsg'
-	if logTee != nil && le.tee {
-		printTee(msg)
+// sendMsg queues a message taken from pool for the writer goroutine, or a
+// LogBytes message if pool is nil. If the writer was stopped since the
+// message was taken, it returns ErrMessageDropped and frees the message,
+// unless Configure replaced its pool, which is dropped with it.
+func sendMsg(msg *logMessage, pool chan *logMessage) error {
+	queueMu.RLock()
+	defer queueMu.RUnlock()
+	if atomic.LoadInt32(&writerStopped) == 1 || (pool != nil && pool != freeMessages) {
+		if pool == nil || pool == freeMessages {
+			_ = freeMsg(msg)
+		}
+		return ErrMessageDropped
 	}
 
-	// queue the message
 	select {
 	case messages <- msg:
-		// no-op
+		return nil
 	default:
 		// this should never happen since there is an exact number of messages
 		atomic.AddUint64(&errCount, 1)
+		_ = freeMsg(msg)
 		return ErrLogFullBuf
 	}
+}
+
+// releaseMsg frees a message taken from pool that won't be queued, unless
+// Configure replaced the pool meanwhile.
+func releaseMsg(msg *logMessage, pool chan *logMessage) {
+	queueMu.RLock()
+	defer queueMu.RUnlock()
+	if pool == freeMessages {
+		_ = freeMsg(msg)
+	}
+}
+
+// SetSyncLevel makes logging a message at level or a more severe one wait
+// until the writer goroutine has written it, and any socket batch with it,
+// so an error logged just before a crash isn't lost. Less severe messages
+// are still written asynchronously, and so are access logs, whatever the
+// level. Messages dropped because the pool is empty are not waited for,
+// and waiting gives up after syncWriteTimeout if the writer is stuck. The
+// default of Levels.Off never waits.
+func SetSyncLevel(level Level) {
+	syncLevel = level
+}
+
+// waitWritten waits for a message logged at the sync level to be written.
+func waitWritten(written chan struct{}) {
+	timer := time.NewTimer(syncWriteTimeout)
+	defer timer.Stop()
+	select {
+	case <-written:
+	case <-timer.C:
+	}
+}
+
+// render fills the message buffer from its log entry using the configured
+// format, and adds the C null terminator.
+func render(msg *logMessage) (err error) {
+	stamp(msg)
+	return renderBody(msg)
+}
+
+// stamp sets the time and syslog level of a message and the fields the
+// logger adds to it.
+func stamp(msg *logMessage) {
+	msg.time = nowFn()
+	if useUTC {
+		msg.time = msg.time.UTC()
+	}
+	msg.level = levelSysLog[msg.le.lvl]
+	if msg.le.goid != 0 {
+		msg.meta = append(msg.meta, Field{"goid", msg.le.goid})
+	}
+	if monotonicTimestamps {
+		msg.meta = append(msg.meta, Field{"mono_ns", int64(msg.time.Sub(processStart))})
+	}
+}
+
+// renderBody formats a stamped message into its buffer.
+func renderBody(msg *logMessage) (err error) {
+	if err = format(msg); err != nil {
+		return
+	}
+	if strictFormat && msg.le.raw == nil && bytes.Contains(msg.Bytes(), badVerb) {
+		atomic.AddUint64(&errCount, 1)
+		le := &msg.le
+		le.fmt, le.fmtV = badFormat, []interface{}{le.fmt, le.fmtV}
+		msg.Truncate(0)
+		if err = format(msg); err != nil {
+			return
+		}
+	}
+	if maxMessageBytes > 0 && msg.Len() > maxMessageBytes && !sendJSON && !cefFormat {
+		// JSON and CEF messages truncate the message text instead, so they
+		// stay valid
+		cut := truncateLen(msg.Bytes(), maxMessageBytes)
+		dropped := msg.Len() - cut
+		msg.Truncate(cut)
+		fmt.Fprintf(msg, truncatedMarker, dropped)
+		atomic.AddUint64(&truncateCount, 1)
+	}
+	return msg.WriteByte(0)
+}
+
+// SetDeferredRendering formats messages on the writer goroutine instead of
+// the goroutine logging them, which takes the formatting cost off the
+// logging path. The arguments of a message are then read after the logging
+// call returns, so they must not be modified afterwards: pass copies, or
+// values rendered with fmt.Sprint, of anything that may change. The tee is
+// fed from the writer goroutine too. Messages are still timestamped when
+// logged.
+func SetDeferredRendering(enabled bool) {
+	deferredRendering = enabled
+}
+
+// badVerb starts the markers fmt writes for verbs that don't match their
+// arguments, such as %!d(string=x) or %!(EXTRA int=1); badFormat is the
+// message logged instead in strict mode.
+var (
+	badVerb   = []byte("%!")
+	badFormat = "[bad format] %q args %v"
+)
+
+// SetStrictFormat checks the verbs of format strings against their
+// arguments as messages are rendered. A message with missing, extra or
+// mismatched arguments is logged instead as "[bad format]" followed by its
+// format string and arguments, at its level, and counted as an error in
+// Stats. It catches misuse that would otherwise leave %!(EXTRA ...) noise in
+// production logs. The check looks for the markers fmt leaves in the
+// rendered message, so a message that logs "%!" as text is also caught. It
+// is off by default.
+func SetStrictFormat(enabled bool) {
+	strictFormat = enabled
+}
+
+// SetMaxMessageBytes limits rendered messages to n bytes, followed by a
+// marker of how many bytes were cut, so a runaway message can't bloat the
+// message buffers. For JSON messages, the message text is limited instead.
+// The default of zero means no limit.
+func SetMaxMessageBytes(n int) {
+	maxMessageBytes = n
+}
+
+// truncateLen returns the length of b cut down to at most n bytes without
+// splitting a UTF-8 rune.
+func truncateLen(b []byte, n int) int {
+	if len(b) <= n {
+		return len(b)
+	}
+	for n > 0 && !utf8.RuneStart(b[n]) {
+		n--
+	}
+	return n
+}
+
+// asString renders the message as: level prefix, message body
+func asString(msg *logMessage) (err error) {
+	le := &msg.le
+	if _, err = msg.Write(levelFmt(le.lvl)); err != nil {
+		return
+	}
+	if _, err = msg.WriteString(le.pre); err != nil {
+		return
+	}
+	if le.lc.File != "" {
+		if _, err = fmt.Fprintf(msg, "<%s: %d> ", le.lc.File, le.lc.Line); err != nil {
+			return
+		}
+	}
+	if le.raw != nil {
+		_, err = msg.Write(le.raw)
+	} else {
+		_, err = fmt.Fprintf(msg, le.fmt, le.fmtV...)
+	}
+	if err != nil {
+		return
+	}
 
+	if static := staticFieldsFor(le); len(static) > 0 || len(le.fields) > 0 || len(msg.meta) > 0 {
+		msg.Truncate(len(bytes.TrimRight(msg.Bytes(), "\n")))
+		writeFieldsString(&msg.Buffer, static, le.fields, msg.meta)
+	}
 	return
 }
 
-// Send to a tee
-func printTee(msg *logMessage) {
+// jsonEncoder is a json.Encoder that can be pointed at a new message. The
+// standard library has no way to retarget an Encoder, so it writes through
+// a writer whose destination is swapped for every message. The entry and
+// caller being encoded live in the pooled object too, so they don't escape
+// to the heap on every call.
+type jsonEncoder struct {
+	dst    io.Writer
+	enc    *json.Encoder
+	entry  logEntryStructured
+	caller logCaller
+}
+
+func (je *jsonEncoder) Write(p []byte) (int, error) {
+	return je.dst.Write(p)
+}
+
+// jsonEncoders avoids allocating the encoding state for every message.
+var jsonEncoders = sync.Pool{
+	New: func() interface{} {
+		je := &jsonEncoder{}
+		je.enc = json.NewEncoder(je)
+		return je
+	},
+}
+
+// asJSON renders the message as a single JSON object followed by a newline.
+func asJSON(msg *logMessage) error {
+	le := &msg.le
+	je := jsonEncoders.Get().(*jsonEncoder)
+	defer jsonEncoders.Put(je)
+
+	m := jsonMessage(le)
+	je.caller = le.lc
+	je.entry = logEntryStructured{
+		Time:    jsonTime(msg.time),
+		Name:    logNameString,
+		Level:   le.lvl.String(),
+		Prefix:  strings.TrimSpace(le.pre),
+		Caller:  &je.caller,
+		Message: m,
+	}
+	switch {
+	case le.lc.File == "": // see SetIncludeCaller
+		je.entry.Caller = nil
+	case callerAsString:
+		je.entry.Caller = le.lc.String()
+	}
+
+	je.dst = msg
+	var err error
+	if jsonFieldNames != nil {
+		err = je.enc.Encode(je.entry.renamed())
+	} else {
+		err = je.enc.Encode(&je.entry)
+	}
+	je.dst = nil
+	je.entry = logEntryStructured{} // don't pin the message in the pool
+	if err != nil {
+		return err
+	}
+
+	return writeFieldsJSON(&msg.Buffer, staticFieldsFor(le), le.fields, promotedFields(le, m), msg.meta)
+}
+
+// SetJSONFieldNames renames the standard keys of JSON messages: time, name,
+// level, prefix, caller and message. Keys that aren't in names keep their
+// default name, and a nil or empty map restores the defaults. For example,
+// map[string]string{"name": "logName"} logs the log name as "logName".
+func SetJSONFieldNames(names map[string]string) {
+	if len(names) == 0 {
+		jsonFieldNames = nil
+		return
+	}
+	jsonFieldNames = make(map[string]string, len(names))
+	for k, v := range names {
+		jsonFieldNames[k] = v
+	}
+}
+
+// renamed returns the entry as a map keyed by the names set with
+// SetJSONFieldNames. Note that encoding/json writes map keys in sorted
+// order rather than in the order of the struct.
+func (e *logEntryStructured) renamed() map[string]interface{} {
+	m := make(map[string]interface{}, 6)
+	set := func(key string, val interface{}) {
+		if name, ok := jsonFieldNames[key]; ok && name != "" {
+			key = name
+		}
+		m[key] = val
+	}
+	set("time", e.Time)
+	set("name", e.Name)
+	set("level", e.Level)
+	set("prefix", e.Prefix)
+	if e.Caller != nil {
+		set("caller", e.Caller)
+	}
+	set("message", e.Message)
+	return m
+}
+
+// jsonMessage formats the message text of a JSON or CEF message, limited to
+// maxMessageBytes.
+func jsonMessage(le *logEntry) string {
+	m := trimNewLines(le.text())
+	if maxMessageBytes > 0 && len(m) > maxMessageBytes {
+		cut := truncateLen([]byte(m), maxMessageBytes)
+		m = m[:cut] + fmt.Sprintf(truncatedMarker, len(m)-cut)
+		atomic.AddUint64(&truncateCount, 1)
+	}
+	return m
+}
+
+// SetTrimNewlines sets whether trailing newlines are stripped from messages,
+// which they are by default. Turn it off to keep the trailing blank lines of
+// deliberately multi-line messages, such as a formatted table. Lines written
+// to stdout still end in a newline either way.
+func SetTrimNewlines(enabled bool) {
+	trimNewlines = enabled
+}
+
+// trimNewLines strips all trailing newlines from s, unless turned off with
+// SetTrimNewlines.
+func trimNewLines(s string) string {
+	if !trimNewlines {
+		return s
+	}
+	return strings.TrimRight(s, "\n")
+}
+
+// stdString is the message as printed to stdout and the tee: a time and
+// log name leader, with the host name and PID if enabled, followed by the
+// message, without the C null-termination byte or trailing newlines. JSON
+// and CEF messages are printed without the leader, which would make them
+// unparseable.
+func stdString(msg *logMessage) string {
 	// remove C null-termination byte
 	message := string(msg.Bytes()[:msg.Len()-1])
-	message = strings.TrimRight(message, "\n")
+	if sendJSON || cefFormat {
+		return strings.TrimRight(message, "\n") // newlines in the message are escaped
+	}
+	message = trimNewLines(message)
+
+	b := make([]byte, 0, len(STDOUT_FORMAT)+len(hostname)+len(logNameString)+len(message)+16)
+	if timeLayout != "" {
+		b = append(msg.time.AppendFormat(b, timeLayout), ' ')
+	} else {
+		b = appendTimestamp(b, msg.time)
+	}
+	if includeHostname {
+		b = append(append(b, hostname...), ' ')
+	}
+	b = append(b, logNameString...)
+	if includePID {
+		b = append(strconv.AppendInt(append(b, '['), int64(pid), 10), "]: "...)
+	}
+	b = append(b, message...)
+	return string(b)
+}
+
+// writeTee sends the message to the tees. If a tee is full, it waits up to
+// the tee timeout before dropping the message for that tee and counting it in
+// TeeDrops. Drops are only counted, not logged, since logging about a full
+// tee would only add to the backlog.
+func writeTee(msg *logMessage) error {
+	line := stdString(msg)
+	if redactor != nil {
+		line = string(redactor([]byte(line)))
+	}
+	var err error
+	for _, t := range loadTees().str {
+		if !t.send(line) {
+			atomic.AddUint64(&t.drops, 1)
+			atomic.AddUint64(&teeDropCount, 1)
+			err = ErrTeeFull
+		}
+	}
+	return err
+}
+
+// writeEntryTees sends the message to the entry tees with its write sequence
+// number, counting drops the same way as writeTee. The message has already
+// been redacted.
+func writeEntryTees(msg *logMessage, entryTees []*entryTee, seq uint64) {
+	e := TeeEntry{
+		Seq:    seq,
+		Time:   msg.time,
+		Level:  msg.le.lvl,
+		Prefix: msg.le.pre,
+		Line:   stdString(msg),
+	}
+	for _, t := range entryTees {
+		if !t.send(e) {
+			atomic.AddUint64(&t.drops, 1)
+			atomic.AddUint64(&teeDropCount, 1)
+		}
+	}
+}
+
+// send offers e to the entry tee, waiting up to the tee timeout for room.
+func (t *entryTee) send(e TeeEntry) bool {
 	select {
-	case logTee <- fmt.Sprintf("%s%s%s", msg.time.Format(STDOUT_FORMAT), logNameString, message):
+	case t.ch <- e:
+		return true
 	default:
-		LogNoTee(Levels.Error, "[meta log]", "%s log tee is full", logTee)
 	}
-	return
+
+	if teeTimeout > 0 {
+		timer := time.NewTimer(teeTimeout)
+		defer timer.Stop()
+		select {
+		case t.ch <- e:
+			return true
+		case <-timer.C:
+		}
+	}
+	return false
 }
 
-// printStd prints msg to stdhdl
-func printStd(msg *logMessage) (err error) {
-	// remove C null-termination byte
-	message := string(msg.Bytes()[:msg.Len()-1])
-	message = strings.TrimRight(message, "\n")
-	fmt.Fprintf(stdhdl, "%s%s%s\n", msg.time.Format(STDOUT_FORMAT), logNameString, message)
-	return
+// send offers line to the tee, waiting up to the tee timeout for room.
+func (t *tee) send(line string) bool {
+	select {
+	case t.ch <- line:
+		return true
+	default:
+	}
+
+	if teeTimeout > 0 {
+		timer := time.NewTimer(teeTimeout)
+		defer timer.Stop()
+		select {
+		case t.ch <- line:
+			return true
+		case <-timer.C:
+		}
+	}
+	return false
 }
 
-// write function writes a message to syslog. This is a concrete, blocking event.
-func write(msg *logMessage) (err error) {
-	start := (*C.char)(unsafe.Pointer(&msg.Bytes()[0]))
-	if _, err = C.csyslog(C.LOG_USER|msg.level, start); err != nil {
-		atomic.AddUint64(&errCount, 1)
+// socketPayload is a message as sent over sockets. JSON messages are sent as
+// one newline terminated object, without the C null terminator, which
+// confuses collectors expecting newline delimited JSON.
+func socketPayload(msg *logMessage) []byte {
+	if sendJSON {
+		return msg.Bytes()[:msg.Len()-1]
 	}
-	return
+	return msg.Bytes()
 }
 
 // writeCustomSocket writes a message to a pre-defined custom socket.
-// This is a concrete, blocking event. Writes out using the syslog rfc5424 format.
-func writeCustomSocket(msg *logMessage) (err error) {
-	if _, err = customSock.Write(bytes.Join([][]byte{[]byte(fmt.Sprintf("<%d>", C.LOG_USER|msg.level)),
-		msg.Bytes()}, []byte(""))); err != nil {
+// This is a concrete, blocking event. Writes out using the syslog rfc5424 format,
+// or just "<PRI>message" with SetSyslogLegacyFormat. Stream sockets get
+// octet counted frames, see SetSyslogFraming. With SocketFormatRaw, messages
+// are written as newline terminated lines instead.
+// A failed write reconnects the socket and retries the message once.
+func writeCustomSocket(msg *logMessage) error {
+	return writeCustomSocketFrames(customSocketFrame(msg))
+}
+
+// customSocketFrame returns the framed message to write to the custom socket.
+func customSocketFrame(msg *logMessage) []byte {
+	if socketFormat == SocketFormatRaw {
+		return rawSocketFrame(msg)
+	}
+	var frame []byte
+	if syslogLegacyFormat {
+		frame = bytes.Join([][]byte{[]byte(fmt.Sprintf("<%d>", priority(msg))),
+			socketPayload(msg)}, []byte(""))
+	} else {
+		frame = appendRFC5424(nil, priority(msg), msg)
+	}
+	return frameSyslog(frame)
+}
+
+// writeCustomSocketFrames writes one or more framed messages to the custom
+// socket, reconnecting it and retrying once if the write fails. With
+// compression on they are only collected, see SetCompression.
+func writeCustomSocketFrames(frames []byte) error {
+	if compressedSocket != nil {
+		return compressedSocket.add(frames)
+	}
+	return writeCustomSocketData(func() []byte { return frames })
+}
+
+// writeCustomSocketData writes what data returns to the custom socket,
+// reconnecting it and retrying once if the write fails. data is called for
+// each attempt, once the socket is connected.
+func writeCustomSocketData(data func() []byte) (err error) {
+	if !CustomSocketConnected() {
+		err = redialCustomSocket()
+	}
+	if err == nil {
+		if _, err = customSock.Write(data()); err != nil {
+			// the remote end may have restarted
+			customSock.Close()
+			atomic.StoreInt32(&customSockConnected, 0)
+			if err = redialCustomSocket(); err == nil {
+				_, err = customSock.Write(data())
+			}
+		}
+	}
+	if err != nil {
 		atomic.AddUint64(&errCount, 1)
 	}
 	return
 }
 
+// redialCustomSocket reconnects the custom socket. Failed attempts double the
+// time to wait before the next one, up to maxRedialBackoff, so a down remote
+// end doesn't keep the writer goroutine busy dialing.
+func redialCustomSocket() error {
+	now := time.Now()
+	if now.Before(redialAt) {
+		return ErrCustomSocketDown
+	}
+
+	var conn net.Conn
+	var err error
+	if customSockTLS != nil {
+		dialer := &net.Dialer{Timeout: redialTimeout}
+		conn, err = tls.DialWithDialer(dialer, customSockNetwork, customSockAddress, customSockTLS)
+	} else {
+		conn, err = net.DialTimeout(customSockNetwork, customSockAddress, redialTimeout)
+	}
+	if err != nil {
+		switch {
+		case redialBackoff == 0:
+			redialBackoff = minRedialBackoff
+		case redialBackoff < maxRedialBackoff:
+			redialBackoff *= 2
+			if redialBackoff > maxRedialBackoff {
+				redialBackoff = maxRedialBackoff
+			}
+		}
+		redialAt = now.Add(redialBackoff)
+		return err
+	}
+
+	customSock = conn
+	redialBackoff, redialAt = 0, time.Time{}
+	atomic.StoreInt32(&customSockConnected, 1)
+	return nil
+}
+
+// addWriterField adds a field to a message that was already rendered, for
+// fields only known once the writer goroutine picks the message up.
+func addWriterField(msg *logMessage, f Field) {
+	b := msg.Bytes()[:msg.Len()-1] // without the C null terminator
+	if sendJSON {
+		msg.Truncate(len(b))
+		_ = writeFieldsJSON(&msg.Buffer, []Field{f}) // only fails for unmarshalable values
+	} else if cefFormat {
+		msg.Truncate(len(b))
+		writeCEFExtension(&msg.Buffer, f)
+	} else {
+		msg.Truncate(len(bytes.TrimRight(b, "\n")))
+		writeFieldString(&msg.Buffer, f)
+	}
+	msg.WriteByte(0)
+}
+
+// SetIncludeDelta adds a delta_ms field to every message with the
+// milliseconds elapsed between the time it was logged and the time the
+// previously written message was logged. Deltas follow the order messages
+// are written in, not the order they were logged in, so messages logged
+// concurrently can have negative deltas.
+func SetIncludeDelta(enabled bool) {
+	includeDelta = enabled
+}
+
+// addDeltaField adds the delta_ms field to msg. It must only be called from
+// the writer goroutine.
+func addDeltaField(msg *logMessage) {
+	var delta time.Duration
+	if !lastWriteTime.IsZero() {
+		delta = msg.time.Sub(lastWriteTime)
+	}
+	lastWriteTime = msg.time
+	addWriterField(msg, Field{"delta_ms", float64(delta.Microseconds()) / 1000})
+}
+
+// SetIncludeSequence adds a seq field to every message, numbering messages
+// from 1 in the order they are written, so that downstream gaps reveal
+// dropped messages. Messages dropped before reaching the writer, because the
+// pool was exhausted, don't use up a number; see Stats for those. Entry tees
+// share the numbering, see AddEntryTee, so with one added the first number
+// can be higher than 1.
+func SetIncludeSequence(enabled bool) {
+	includeSequence = enabled
+}
+
+// writeMsg writes a rendered message to its output, and to every sink added
+// with AddSink.
+func writeMsg(msg *logMessage) {
+	if msg.le.out != nil {
+		reportWriteError(msg.le.out.writeLog(msg))
+	} else if id := routeMsg(msg); id != DefaultOutput {
+		reportWriteError(writeOutput(id, msg))
+	} else {
+		reportWriteError(defaultSink(msg).writeLog(msg))
+	}
+	for _, s := range sinks {
+		reportWriteError(s.writeLog(msg))
+	}
+}
+
+// SetWriteRetries makes the writer retry a failed write to a network output
+// up to n times, sleeping backoff between attempts, before dropping the
+// message, or every message of a socket batch; see SetSocketBatching. It
+// doesn't apply to stdout or files. Retries block the writer
+// goroutine, so keep n and backoff small.
+func SetWriteRetries(n int, backoff time.Duration) {
+	writeRetries, writeRetryBackoff = n, backoff
+}
+
+// writeWithRetries writes msg with write, retrying as set by SetWriteRetries.
+// A message that can't be written is counted as dropped.
+func writeWithRetries(write func(*logMessage) error, msg *logMessage) error {
+	return retryWrite(func() error { return write(msg) }, 1)
+}
+
+// retryWrite calls write, retrying as set by SetWriteRetries. If it keeps
+// failing, the n messages it writes are counted as dropped.
+func retryWrite(write func() error, n uint64) (err error) {
+	for attempt := 0; ; attempt++ {
+		if err = write(); err == nil {
+			return
+		}
+		if attempt >= writeRetries {
+			atomic.AddUint64(&dropCount, n)
+			return
+		}
+		time.Sleep(writeRetryBackoff)
+	}
+}
+
+// writeMessage writes a queued message: it renders it if rendering was
+// deferred, adds the fields only known to the writer, redacts it, sends it
+// to the entry tees and writes it to its outputs, then frees it. It must
+// run on the writer goroutine.
+func writeMessage(msg *logMessage) {
+	if msg.Len() == 0 { // deferred rendering
+		if err := renderBody(msg); err != nil {
+			atomic.AddUint64(&errCount, 1)
+			freeMsg(msg)
+			return
+		}
+		if teeMsg(&msg.le) {
+			_ = writeTee(msg)
+		}
+	}
+	if includeDelta {
+		addDeltaField(msg)
+	}
+	entryTees := loadTees().entries
+	if includeSequence || len(entryTees) > 0 {
+		writeSeq++
+	}
+	if includeSequence {
+		addWriterField(msg, Field{"seq", writeSeq})
+	}
+	if redactor != nil {
+		redact(msg)
+	}
+	if len(entryTees) > 0 && teeable(&msg.le) {
+		writeEntryTees(msg, entryTees, writeSeq)
+	}
+	writeMsg(msg)
+	if msg.written != nil {
+		flushSocketBatch() // see SetSyncLevel
+		flushCompression()
+	}
+	freeMsg(msg)
+}
+
 // logWriter will write out messages to syslog. It may block if something breaks
-// within the syslog call.
+// within the syslog call. If the writer watchdog is enabled, a panic stops the
+// writer and is handed to the watchdog, which starts a new one, and so does
+// any other exit before the queue is closed.
 func logWriter() {
-	for msg := range messages {
-		if stdhdl != nil {
-			printStd(msg)
-		} else if customSock == nil {
-			write(msg)
-		} else {
-			writeCustomSocket(msg)
+	summaries := time.NewTicker(summaryInterval)
+	defer summaries.Stop()
+
+	var inFlight *logMessage
+	finished := false
+	defer func() {
+		if atomic.LoadInt32(&watchdogEnabled) == 1 && !finished {
+			r := recover()
+			if r == nil {
+				r = errWriterExited
+			}
+			atomic.AddUint64(&errCount, 1)
+			if inFlight != nil {
+				_ = freeMsg(inFlight)
+			}
+			select {
+			case writerCrashed <- r:
+			default: // the watchdog gave up
+			}
+		}
+	}()
+
+	var batchDue <-chan time.Time       // fires when the socket batch is due; see SetSocketBatching
+	var compressionDue <-chan time.Time // fires when compressed output is due; see SetCompression
+	markWriter()
+	defer atomic.StoreInt32(&writerBusy, 0)
+	for done := false; !done; {
+		writerBeat(false)
+		select {
+		case msg, ok := <-messages:
+			writerBeat(true)
+			if !ok {
+				done = true
+				break
+			}
+			if msg.control != nil {
+				msg.control()
+				freeMsg(msg)
+				break
+			}
+			inFlight = msg
+			writeMessage(msg)
+			inFlight = nil
+		case <-batchDue:
+			writerBeat(true)
+			batchDue = nil
+			flushSocketBatch()
+		case <-compressionDue:
+			writerBeat(true)
+			compressionDue = nil
+			flushCompression()
+		case <-summaries.C:
+			writerBeat(true)
+			flushSuppressed()
+		}
+
+		if socketBatchCount == 0 {
+			batchDue = nil
+		} else if batchDue == nil {
+			batchDue = time.After(socketBatchInterval)
+		}
+		if !compressionPending() {
+			compressionDue = nil
+		} else if compressionDue == nil {
+			compressionDue = time.After(compressionInterval)
 		}
-		freeMsg(msg)
-	}
-	if customSock != nil {
-		customSock.Close()
 	}
+	flushSuppressed() // the last summaries
+	flushSocketBatch()
+	closeCompression()
+
+	finished = true
 	close(logWriterFinished)
 }
 
-// Close shuts down the logger system. After Close is called, any additional
-// logs will panic. Only call this if you are completely done.
+// Close shuts down the logger system, once the pending messages are written,
+// and flushes outputs that buffer, such as AsyncWriter. After Close is
+// called, any additional logs will panic, until Reinit is called; messages
+// logged while it runs are dropped. Calling Close again only waits for the
+// writer to finish.
 func Close(ctx context.Context) error {
-	close(messages)
+	writerMu.Lock()
+	defer writerMu.Unlock()
+
+	if atomic.LoadInt32(&writerStopped) == 0 {
+		if emitLifecycle {
+			logClosed()
+		}
+		stopWriter(true)
+	}
 	select {
 	case <-logWriterFinished:
-		return nil
+		if customSock != nil && CustomSocketConnected() {
+			customSock.Close()
+			atomic.StoreInt32(&customSockConnected, 0) // redialed after Reinit
+		}
+		return flushOutputs(ctx, outputWriters())
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush blocks until every message queued before it was called has been
+// written, or the context is canceled. Unlike DrainContext it doesn't poll,
+// and it doesn't wait for messages queued after it, so it returns even while
+// other goroutines keep logging. It also writes out the pending socket batch,
+// see SetSocketBatching, and compressed output, see SetCompression, and
+// flushes outputs that buffer, such as AsyncWriter. Use it before exiting.
+// After Close, which flushes everything itself, it returns ErrLoggerClosed.
+func Flush(ctx context.Context) error {
+	flushed := make(chan []io.Writer, 1)
+	if err := runOnWriter(ctx, func() {
+		flushSocketBatch()
+		flushCompression()
+		flushed <- outputWriters()
+	}); err != nil {
+		return err
+	}
+
+	select {
+	case ws := <-flushed:
+		return flushOutputs(ctx, ws)
 	case <-ctx.Done():
 		return ctx.Err()
 	}
 }
 
+// runOnWriter queues a sentinel message that makes the writer goroutine run
+// f once it has written every message queued before it. It returns
+// ErrLoggerClosed after Close, when there is no writer goroutine, and waits
+// for Configure to start the new one.
+func runOnWriter(ctx context.Context, f func()) error {
+	for {
+		pool, stop, err := currentPool()
+		if err == nil {
+			select {
+			case msg := <-pool:
+				msg.control = f
+				if err = sendMsg(msg, pool); err != ErrMessageDropped {
+					return err
+				}
+			case <-stop:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		} else if err != ErrMessageDropped {
+			return err
+		}
+
+		// the writer is stopping: wait until Close, Configure or Reinit is
+		// done, and try again
+		writerMu.Lock()
+		writerMu.Unlock()
+	}
+}
+
 // DrainContext blocks until it sees no pending messages or the context is canceled.
 // Pending messages may never run out if another goroutine is constantly
-// writing.
+// writing. It is woken up as soon as the last message is back in the pool
+// and, because a message is only freed once written, returns after the last
+// write. Flush waits for the messages queued before it instead.
+//
+// Once drained, it also writes out compressed output; see SetCompression.
+// It returns ErrFreeMessageUnderflow once drained if the pool holds fewer
+// messages than it should; see CheckPool.
 func DrainContext(ctx context.Context) error {
-	for ctx.Err() == nil && (len(messages) > 0 || len(freeMessages) < cap(freeMessages)) {
-		innerCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
-		<-innerCtx.Done() // Wait for 10ms and check the queues again
-		cancel()
+	for ctx.Err() == nil {
+		signal := drainSignal() // before checking, so a drain can't be missed
+		queueMu.RLock()
+		idle := len(messages) == 0 && len(freeMessages) >= int(atomic.LoadInt32(&poolFilled))
+		queueMu.RUnlock()
+		if idle && atomic.LoadInt32(&bytesInFlight) == 0 {
+			atomic.LoadUint64(&freedCount)
+			if err := drainCompression(ctx); err != nil {
+				return err
+			}
+			return CheckPool()
+		}
+		select {
+		case <-signal:
+		case <-ctx.Done():
+		}
 	}
 	return ctx.Err()
 }
 
+// drainCompression flushes the compressed output on the writer goroutine,
+// if compression is on, and waits for it.
+func drainCompression(ctx context.Context) error {
+	if compressedWriter == nil && compressedSocket == nil {
+		return nil
+	}
+	if atomic.LoadInt32(&writerStopped) == 1 {
+		return nil // Close ended the streams
+	}
+	done := make(chan struct{})
+	if err := runOnWriter(ctx, func() { flushCompression(); close(done) }); err != nil {
+		return err
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CheckPool returns ErrFreeMessageUnderflow if the message pool could not be
+// filled with as many messages as configured, which leaves less room for
+// bursts than expected.
+func CheckPool() error {
+	if int(atomic.LoadInt32(&poolFilled)) < cap(freeMessages) {
+		return ErrFreeMessageUnderflow
+	}
+	return nil
+}
+
 // DrainWithTimeout is a helper function that uses the given timeout with
 // DrainContext.
 func DrainWithTimeout(d time.Duration) {
@@ -333,21 +1655,99 @@ func Drain() {
 	_ = DrainContext(context.Background())
 }
 
-func setup() {
-	stdhdl = nil
-	messages = make(chan *logMessage, NumMessages)
-	freeMessages = make(chan *logMessage, NumMessages)
-	msgArr := make([]logMessage, NumMessages)
-	for i := range msgArr {
-		if err := freeMsg(&msgArr[i]); err != nil {
-			break
+// Configure sets the number of messages in the fixed set, which is
+// NumMessages by default. Smaller pools save memory, larger ones drop less
+// under bursts. It is meant to be called at startup, before anything is
+// logged. Calling it after logging has started drains the pending messages
+// and rebuilds the pool; messages logged concurrently while it runs may be
+// dropped.
+func Configure(size int) {
+	if size <= 0 {
+		size = NumMessages
+	}
+
+	writerMu.Lock()
+	defer writerMu.Unlock()
+
+	// let the writer finish the pending messages of the old pool
+	stopWriter(false)
+	<-logWriterFinished
+
+	poolSize = size
+	startWriter()
+}
+
+// Reinit starts the logger system again after Close, with a new message
+// pool and writer goroutine and the same settings and outputs. It does
+// nothing if the logger is not closed.
+func Reinit() {
+	writerMu.Lock()
+	defer writerMu.Unlock()
+
+	if atomic.LoadInt32(&writerStopped) == 1 {
+		startWriter()
+		if emitLifecycle {
+			logStarted()
 		}
 	}
+}
+
+// stopWriter closes the message queue, once, making the writer goroutine
+// finish. closing tells Close from Configure. Callers hold writerMu.
+func stopWriter(closing bool) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	if atomic.LoadInt32(&writerStopped) == 0 {
+		atomic.StoreInt32(&writerStopped, 1)
+		writerClosed = closing
+		close(stopping)
+		close(messages)
+	}
+}
 
+// startWriter creates the message pool and starts the writer goroutine.
+// Callers hold writerMu, except setup.
+func startWriter() {
+	queueMu.Lock()
+	messages = make(chan *logMessage, 2*poolSize) // with room for LogBytes
+	freeMessages = make(chan *logMessage, poolSize)
+	msgArr := make([]logMessage, poolSize)
+	n := fillPool(msgArr)
+	if n < poolSize { // try the rest once more
+		runtime.Gosched()
+		n += fillPool(msgArr[n:])
+	}
+	atomic.StoreInt32(&poolFilled, int32(n))
+	stopping = make(chan struct{})
+	writerClosed = false
 	logWriterFinished = make(chan struct{}, 1)
+	atomic.StoreInt32(&writerStopped, 0)
+	queueMu.Unlock()
+
 	go logWriter()
+
+	if n < poolSize {
+		LogNoTee(Levels.Error, "[meta log]", "message pool holds only %d of %d messages", n, poolSize)
+	}
+}
+
+// fillPool adds msgs to the pool, stopping at the first that doesn't fit,
+// and returns how many were added.
+func fillPool(msgs []logMessage) int {
+	for i := range msgs {
+		if err := freeMsg(&msgs[i]); err != nil {
+			return i
+		}
+	}
+	return len(msgs)
+}
+
+func setup() {
+	stdhdl = nil
+	startWriter()
 }
 
 func init() {
+	setFormat()
 	setup()
 }
@@ -2,11 +2,22 @@ package logger
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -81,7 +92,7 @@ func TestSetTee(t *testing.T) {
 	Drain()
 	close(done)
 	close(teeCh)
-	logTee = nil
+	SetTee(nil)
 }
 
 func TestLogNoTee(t *testing.T) {
@@ -109,7 +120,7 @@ func TestLogNoTee(t *testing.T) {
 	Drain()
 	close(done)
 	close(teeCh)
-	logTee = nil
+	SetTee(nil)
 
 	// ensure only teed messages was teed
 	teeLogs := teeBuf.String()
@@ -121,6 +132,504 @@ func TestLogNoTee(t *testing.T) {
 	}
 }
 
+func TestSetTeeLevel(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetTee(nil)
+	defer SetTeeLevel(Levels.Debug)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	teeCh := make(chan string, 5)
+	SetTee(teeCh)
+	SetTeeLevel(Levels.Warn)
+
+	log := New(Levels.Info)
+	log.Infof("", "info only on stdout")
+	log.Warnf("", "warn on both")
+	Drain()
+
+	if !strings.Contains(buf.String(), "info only on stdout") || !strings.Contains(buf.String(), "warn on both") {
+		t.Errorf("expected both messages on stdout but got %q", buf.String())
+	}
+	if n := len(teeCh); n != 1 {
+		t.Fatalf("expected 1 teed message but got %d", n)
+	}
+	if teed := <-teeCh; !strings.Contains(teed, "warn on both") {
+		t.Errorf("expected the warning in the tee but got %q", teed)
+	}
+}
+
+func Test_asString(t *testing.T) {
+	msg := &logMessage{le: logEntry{
+		lvl:  Levels.Warn,
+		pre:  "[pre] ",
+		fmt:  "hello %s",
+		fmtV: []interface{}{"world"},
+		lc:   logCaller{File: "a/b.go", Line: 42},
+	}}
+	if err := asString(msg); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[Warn] [pre] <a/b.go: 42> hello world"; msg.String() != want {
+		t.Errorf("expected %q but got %q", want, msg.String())
+	}
+}
+
+func Test_asJSON(t *testing.T) {
+	defer func(orig bool) { callerAsString = orig }(callerAsString)
+	tm := time.Date(2021, 5, 4, 3, 2, 1, 0, time.UTC)
+	newMsg := func() *logMessage {
+		return &logMessage{time: tm, le: logEntry{
+			lvl:  Levels.Error,
+			pre:  " [pre] ",
+			fmt:  "hello %s\n",
+			fmtV: []interface{}{"world"},
+			lc:   logCaller{File: "a/b.go", Line: 229},
+		}}
+	}
+
+	callerAsString = false
+	msg := newMsg()
+	if err := asJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(msg.Bytes(), []byte("}\n")) {
+		t.Errorf("expected a newline terminated object but got %q", msg.String())
+	}
+
+	var nested struct {
+		Time    time.Time `json:"time"`
+		Level   string    `json:"level"`
+		Prefix  string    `json:"prefix"`
+		Message string    `json:"message"`
+		Caller  struct {
+			File string `json:"file"`
+			Line int    `json:"line"`
+		} `json:"caller"`
+	}
+	if err := json.Unmarshal(msg.Bytes(), &nested); err != nil {
+		t.Fatalf("cannot decode %q: %v", msg.String(), err)
+	}
+	if !nested.Time.Equal(tm) || nested.Level != "Error" || nested.Prefix != "[pre]" || nested.Message != "hello world" {
+		t.Errorf("unexpected entry: %+v", nested)
+	}
+	if nested.Caller.File != "a/b.go" || nested.Caller.Line != 229 {
+		t.Errorf("unexpected caller: %+v", nested.Caller)
+	}
+
+	callerAsString = true
+	msg = newMsg()
+	if err := asJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	var flat struct {
+		Caller string `json:"caller"`
+	}
+	if err := json.Unmarshal(msg.Bytes(), &flat); err != nil {
+		t.Fatalf("cannot decode %q: %v", msg.String(), err)
+	}
+	if flat.Caller != "a/b.go:229" {
+		t.Errorf("expected flat caller a/b.go:229 but got %q", flat.Caller)
+	}
+}
+
+func TestSetJSONFieldNames(t *testing.T) {
+	defer SetJSONFieldNames(nil)
+	newMsg := func() *logMessage {
+		return &logMessage{time: time.Now(), le: logEntry{
+			lvl:    Levels.Warn,
+			pre:    "[pre] ",
+			fmt:    "hello",
+			lc:     logCaller{File: "a/b.go", Line: 7},
+			fields: []Field{{"user", "x"}},
+		}}
+	}
+
+	SetJSONFieldNames(map[string]string{"name": "logName", "message": "msg", "level": ""})
+	msg := newMsg()
+	if err := asJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(msg.Bytes(), &entry); err != nil {
+		t.Fatalf("cannot decode %q: %v", msg.String(), err)
+	}
+	for _, key := range []string{"time", "logName", "level", "prefix", "caller", "msg", "user"} {
+		if _, ok := entry[key]; !ok {
+			t.Errorf("expected key %q in %q", key, msg.String())
+		}
+	}
+	for _, key := range []string{"name", "message"} {
+		if _, ok := entry[key]; ok {
+			t.Errorf("expected key %q to be renamed in %q", key, msg.String())
+		}
+	}
+	if entry["msg"] != "hello" {
+		t.Errorf("expected msg hello but got %v", entry["msg"])
+	}
+
+	SetJSONFieldNames(nil)
+	msg = newMsg()
+	if err := asJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(msg.Bytes(), []byte(`{"time":`)) || !bytes.Contains(msg.Bytes(), []byte(`"message":"hello"`)) {
+		t.Errorf("expected the default keys but got %q", msg.String())
+	}
+}
+
+func Benchmark_asJSON(b *testing.B) {
+	msg := &logMessage{time: time.Now(), le: logEntry{
+		lvl:  Levels.Info,
+		pre:  "[bench] ",
+		fmt:  "hello %s %d",
+		fmtV: []interface{}{"world", 42},
+		lc:   logCaller{File: "a/b.go", Line: 229},
+	}}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg.Reset()
+		if err := asJSON(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSetMonotonicTimestamps(t *testing.T) {
+	defer SetMonotonicTimestamps(false)
+
+	mono := func() int64 {
+		msg := &logMessage{le: logEntry{lvl: Levels.Info, fmt: "tick"}}
+		if err := render(msg); err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.meta) != 1 || msg.meta[0].Key != "mono_ns" {
+			t.Fatalf("expected a mono_ns field but got %v", msg.meta)
+		}
+		if !strings.Contains(msg.String(), " mono_ns=") {
+			t.Errorf("expected mono_ns in %q", msg.String())
+		}
+		return msg.meta[0].Val.(int64)
+	}
+
+	SetMonotonicTimestamps(true)
+	first := mono()
+	time.Sleep(time.Millisecond)
+	if second := mono(); second-first < int64(time.Millisecond) {
+		t.Errorf("expected at least 1ms between %d and %d", first, second)
+	}
+
+	SetMonotonicTimestamps(false)
+	msg := &logMessage{le: logEntry{lvl: Levels.Info, fmt: "tick"}}
+	if err := render(msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.meta) != 0 {
+		t.Errorf("expected no mono_ns field when disabled but got %v", msg.meta)
+	}
+}
+
+func TestAddDeltaField(t *testing.T) {
+	defer func() { setFormat(); lastWriteTime = time.Time{} }()
+
+	start := time.Now()
+	newMsg := func(offset time.Duration) *logMessage {
+		msg := &logMessage{le: logEntry{lvl: Levels.Info, fmt: "step\n"}}
+		if err := render(msg); err != nil {
+			t.Fatal(err)
+		}
+		msg.time = start.Add(offset)
+		return msg
+	}
+
+	lastWriteTime = time.Time{}
+	for _, tt := range []struct {
+		offset time.Duration
+		want   string
+	}{
+		{0, "step delta_ms=0\x00"},
+		{1500 * time.Microsecond, "step delta_ms=1.5\x00"},
+		{2 * time.Second, "step delta_ms=1998.5\x00"},
+	} {
+		msg := newMsg(tt.offset)
+		addDeltaField(msg)
+		if !strings.HasSuffix(msg.String(), tt.want) {
+			t.Errorf("expected %q but got %q", tt.want, msg.String())
+		}
+	}
+
+	format, sendJSON = asJSON, true
+	msg := newMsg(2*time.Second + time.Millisecond)
+	addDeltaField(msg)
+	var entry map[string]interface{}
+	if err := json.Unmarshal(msg.Bytes()[:msg.Len()-1], &entry); err != nil {
+		t.Fatalf("cannot decode %q: %v", msg.String(), err)
+	}
+	if entry["delta_ms"] != 1.0 {
+		t.Errorf("expected delta_ms 1 but got %v", entry["delta_ms"])
+	}
+}
+
+func Test_setFormat(t *testing.T) {
+	defer setFormat()
+	defer func(fmtEnv, callerEnv string) {
+		os.Setenv("KENTIK_LOG_FMT", fmtEnv)
+		os.Setenv("KENTIK_LOG_CALLER", callerEnv)
+	}(os.Getenv("KENTIK_LOG_FMT"), os.Getenv("KENTIK_LOG_CALLER"))
+
+	tests := []struct {
+		fmtEnv, callerEnv     string
+		json, flatCaller, cef bool
+	}{
+		{"", "", false, false, false},
+		{"json", "", true, false, false},
+		{"JSON", "", true, false, false},
+		{"text", "", false, false, false},
+		{"json", "string", true, true, false},
+		{"json", "object", true, false, false},
+		{"gelf", "", true, false, false},
+		{"ecs", "", true, false, false},
+		{"cef", "", false, false, true},
+	}
+	for _, tt := range tests {
+		os.Setenv("KENTIK_LOG_FMT", tt.fmtEnv)
+		os.Setenv("KENTIK_LOG_CALLER", tt.callerEnv)
+		setFormat()
+		if sendJSON != tt.json || callerAsString != tt.flatCaller || cefFormat != tt.cef {
+			t.Errorf("KENTIK_LOG_FMT=%q KENTIK_LOG_CALLER=%q: got sendJSON=%v callerAsString=%v cefFormat=%v",
+				tt.fmtEnv, tt.callerEnv, sendJSON, callerAsString, cefFormat)
+		}
+	}
+}
+
+// brokenConn is a net.Conn whose writes always fail.
+type brokenConn struct{ net.Conn }
+
+func (brokenConn) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+func (brokenConn) Close() error              { return nil }
+
+func TestCustomSocketReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	defer func(sock net.Conn, addr, network string) {
+		customSock, customSockAddress, customSockNetwork = sock, addr, network
+		atomic.StoreInt32(&customSockConnected, 0)
+		redialBackoff, redialAt = 0, time.Time{}
+	}(customSock, customSockAddress, customSockNetwork)
+
+	// pretend the collector went away after we connected
+	customSock = brokenConn{}
+	customSockAddress, customSockNetwork = ln.Addr().String(), "tcp"
+	atomic.StoreInt32(&customSockConnected, 1)
+
+	received := make(chan string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		n, _ := conn.Read(buf)
+		received <- string(buf[:n])
+	}()
+
+	msg := &logMessage{}
+	msg.WriteString("hello\x00")
+	if err := writeCustomSocket(msg); err != nil {
+		t.Fatalf("expected the message to be retried on a new connection, got %v", err)
+	}
+	if !CustomSocketConnected() {
+		t.Error("expected the socket to be reported as connected")
+	}
+	if got := <-received; !strings.HasSuffix(got, " hello") {
+		t.Errorf("expected the message on the new connection but got %q", got)
+	}
+	customSock.Close()
+
+	// with the collector down, the socket backs off instead of redialing
+	ln.Close()
+	customSock = brokenConn{}
+	if err := writeCustomSocket(msg); err == nil {
+		t.Fatal("expected a write error with the collector down")
+	}
+	if CustomSocketConnected() {
+		t.Error("expected the socket to be reported as disconnected")
+	}
+	if err := writeCustomSocket(msg); err != ErrCustomSocketDown {
+		t.Errorf("expected %v while backing off but got %v", ErrCustomSocketDown, err)
+	}
+}
+
+func TestTeeFull(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+	defer func() { SetTee(nil); teeTimeout = 0 }()
+
+	teeCh := make(chan string, 1) // nobody reads it, so it is full after one message
+	SetTee(teeCh)
+	SetTeeTimeout(time.Millisecond)
+
+	_, _, _, errsBefore := Stats()
+	dropsBefore := TeeDrops()
+
+	log := New(Levels.Debug)
+	for i := 0; i < 5; i++ {
+		log.Infof("[TestTeeFull] ", "message %d", i)
+	}
+	Drain()
+
+	if drops := TeeDrops() - dropsBefore; drops != 4 {
+		t.Errorf("expected 4 tee drops but got %d", drops)
+	}
+	if _, _, _, errs := Stats(); errs != errsBefore {
+		t.Errorf("expected tee drops not to count as errors, got %d new errors", errs-errsBefore)
+	}
+	if strings.Contains(buf.String(), "[meta log]") {
+		t.Errorf("expected tee drops to be counted, not logged, but got %q", buf.String())
+	}
+}
+
+func TestAddTee(t *testing.T) {
+	defer SetTee(nil)
+
+	full := make(chan string) // unbuffered and never read, so always full
+	roomy := make(chan string, 10)
+	SetTee(full)
+	AddTee(roomy)
+
+	dropsBefore := TeeDrops()
+	log := New(Levels.Debug)
+	for i := 0; i < 3; i++ {
+		log.Infof("[TestAddTee] ", "message %d", i)
+	}
+	Drain()
+
+	if drops := TeeDropsFor(full); drops != 3 {
+		t.Errorf("expected 3 drops for the full tee but got %d", drops)
+	}
+	if drops := TeeDropsFor(roomy); drops != 0 {
+		t.Errorf("expected no drops for the tee with room but got %d", drops)
+	}
+	if drops := TeeDrops() - dropsBefore; drops != 3 {
+		t.Errorf("expected 3 tee drops in total but got %d", drops)
+	}
+	if n := len(roomy); n != 3 {
+		t.Errorf("expected 3 messages in the tee with room but got %d", n)
+	}
+}
+
+func TestAddTeeWhileLogging(t *testing.T) {
+	defer SetTee(nil)
+	defer SetDeferredRendering(false)
+	SetDeferredRendering(true) // tees are also read on the writer goroutine
+
+	log := New(Levels.Debug)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			log.Infof("[TestAddTeeWhileLogging] ", "message %d", i)
+		}
+	}()
+	chans := make([]chan string, 10)
+	for i := range chans {
+		chans[i] = make(chan string, 100)
+		AddTee(chans[i])
+		AddEntryTee(make(chan TeeEntry, 100))
+	}
+	<-done
+	Drain()
+
+	if n := len(loadTees().str); n != len(chans) {
+		t.Errorf("expected %d tees but got %d", len(chans), n)
+	}
+}
+
+func TestAddEntryTee(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetIncludeSequence(false)
+	defer SetTee(nil)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+	entries := make(chan TeeEntry, 10)
+	AddEntryTee(entries)
+	SetIncludeSequence(true)
+
+	log := New(Levels.Info)
+	log.Infof("[a] ", "first")
+	log.Warnf("[b] ", "second")
+	Drain()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 || len(entries) != 2 {
+		t.Fatalf("expected 2 lines and 2 entries but got %q and %d entries", buf.String(), len(entries))
+	}
+	for i, want := range []struct {
+		level  Level
+		prefix string
+	}{{Levels.Info, "[a] "}, {Levels.Warn, "[b] "}} {
+		e := <-entries
+		seq, err := strconv.ParseUint(lines[i][strings.LastIndex(lines[i], "seq=")+4:], 10, 64)
+		if err != nil || e.Seq != seq {
+			t.Errorf("expected entry seq to match %q but got %d", lines[i], e.Seq)
+		}
+		if e.Level != want.level || e.Prefix != want.prefix || e.Line != lines[i] {
+			t.Errorf("expected a %v %q entry for %q but got %+v", want.level, want.prefix, lines[i], e)
+		}
+	}
+	if drops := EntryTeeDropsFor(entries); drops != 0 {
+		t.Errorf("expected no entry tee drops but got %d", drops)
+	}
+}
+
+func TestWriteRetries(t *testing.T) {
+	defer SetWriteRetries(0, 0)
+
+	var written []string
+	failures := 0
+	flaky := func(msg *logMessage) error {
+		if failures > 0 {
+			failures--
+			return io.ErrClosedPipe
+		}
+		written = append(written, msg.String())
+		return nil
+	}
+	msg := &logMessage{}
+	msg.WriteString("survivor")
+
+	_, _, dropsBefore, _ := Stats()
+
+	failures = 1
+	if err := writeWithRetries(flaky, msg); err == nil {
+		t.Error("expected an error without retries")
+	}
+
+	SetWriteRetries(2, time.Millisecond)
+	failures = 1
+	if err := writeWithRetries(flaky, msg); err != nil {
+		t.Errorf("expected the retry to succeed but got %v", err)
+	}
+	failures = 3
+	if err := writeWithRetries(flaky, msg); err == nil {
+		t.Error("expected an error once retries ran out")
+	}
+
+	if len(written) != 1 || written[0] != "survivor" {
+		t.Errorf("expected the message to be written once but got %q", written)
+	}
+	if _, _, drops, _ := Stats(); drops-dropsBefore != 2 {
+		t.Errorf("expected 2 drops but got %d", drops-dropsBefore)
+	}
+}
+
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 func randString(n int) string {
@@ -130,3 +639,424 @@ func randString(n int) string {
 	}
 	return string(b)
 }
+
+func TestSetCustomSocketTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	defer func(sock net.Conn, addr, network string) {
+		customSock, customSockAddress, customSockNetwork, customSockTLS = sock, addr, network, nil
+		atomic.StoreInt32(&customSockConnected, 0)
+	}(customSock, customSockAddress, customSockNetwork)
+
+	if err := SetCustomSocketTLS(ln.Addr().String(), "udp", nil); err != ErrNotStreamNetwork {
+		t.Errorf("expected %v but got %v", ErrNotStreamNetwork, err)
+	}
+
+	received := make(chan string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		n, _ := conn.Read(buf)
+		received <- string(buf[:n])
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	if err := SetCustomSocketTLS(ln.Addr().String(), "tcp", &tls.Config{RootCAs: roots}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := customSock.(*tls.Conn); !ok {
+		t.Fatalf("expected a TLS connection but got %T", customSock)
+	}
+
+	msg := &logMessage{}
+	msg.WriteString("hello\x00")
+	if err := writeCustomSocket(msg); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; !strings.HasSuffix(got, " hello") {
+		t.Errorf("expected the message over TLS but got %q", got)
+	}
+	customSock.Close()
+}
+
+func TestFlush(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	w := &blockingWriter{release: make(chan struct{})}
+	stdhdl = w
+
+	log := New(Levels.Info)
+	log.Infof("", "first")
+	log.Infof("", "second")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := Flush(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected Flush to time out with a blocked writer but got %v", err)
+	}
+
+	close(w.release)
+	if err := Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(w.String(), "\n"); got != 2 {
+		t.Errorf("expected both messages written after Flush but got %q", w.String())
+	}
+}
+
+func TestFlushAfterClose(t *testing.T) {
+	defer Reinit()
+	if err := Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Flush(context.Background()); err != ErrLoggerClosed {
+		t.Errorf("expected Flush after Close to fail with ErrLoggerClosed but got %v", err)
+	}
+	if err := ReopenOutput(); err != ErrLoggerClosed {
+		t.Errorf("expected ReopenOutput after Close to fail with ErrLoggerClosed but got %v", err)
+	}
+}
+
+func TestSetMaxMessageBytes(t *testing.T) {
+	defer SetMaxMessageBytes(0)
+	defer func(f func(*logMessage) error, json bool) { format, sendJSON = f, json }(format, sendJSON)
+	format, sendJSON = asString, false
+
+	SetMaxMessageBytes(9)
+	before := Truncations()
+	msg := &logMessage{le: logEntry{lvl: Levels.Info, fmt: "%s", fmtV: []interface{}{"héllo"}}}
+	if err := render(msg); err != nil {
+		t.Fatal(err)
+	}
+	// the 9th byte is in the middle of "é", so the cut backs off to 8
+	if want := "[Info] h…[truncated 5 bytes]\x00"; msg.String() != want {
+		t.Errorf("expected %q but got %q", want, msg.String())
+	}
+
+	format, sendJSON = asJSON, true
+	SetMaxMessageBytes(2)
+	msg = &logMessage{le: logEntry{lvl: Levels.Info, fmt: "%s", fmtV: []interface{}{"héllo"}}}
+	if err := render(msg); err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(bytes.TrimSuffix(msg.Bytes(), []byte{0}), &entry); err != nil {
+		t.Fatalf("expected valid JSON but got %q: %v", msg.String(), err)
+	}
+	if want := "h…[truncated 5 bytes]"; entry["message"] != want {
+		t.Errorf("expected message %q but got %q", want, entry["message"])
+	}
+	if n := Truncations() - before; n != 2 {
+		t.Errorf("expected 2 truncations but got %d", n)
+	}
+}
+
+func TestSetIncludePID(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer func(name, host string) { logNameString, hostname = name, host }(logNameString, hostname)
+	defer SetIncludePID(false)
+	defer SetIncludeHostname(false)
+	defer SetTimeSource(nil)
+	defer SetIncludeCaller(true)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+	logNameString, hostname = "chf", "host1"
+	SetTimeSource(func() time.Time { return time.Date(2021, 5, 4, 3, 2, 1, 0, time.Local) })
+	SetIncludeCaller(false)
+	log := New(Levels.Info)
+
+	for _, tc := range []struct {
+		pid, host bool
+		leader    string
+	}{
+		{false, false, "2021-05-04T03:02:01.000 chf[Info] hi\n"},
+		{true, false, fmt.Sprintf("2021-05-04T03:02:01.000 chf[%d]: [Info] hi\n", os.Getpid())},
+		{false, true, "2021-05-04T03:02:01.000 host1 chf[Info] hi\n"},
+		{true, true, fmt.Sprintf("2021-05-04T03:02:01.000 host1 chf[%d]: [Info] hi\n", os.Getpid())},
+	} {
+		buf.Reset()
+		SetIncludePID(tc.pid)
+		SetIncludeHostname(tc.host)
+		log.Infof("", "hi")
+		Drain()
+		if buf.String() != tc.leader {
+			t.Errorf("expected %q with pid %v and host %v but got %q", tc.leader, tc.pid, tc.host, buf.String())
+		}
+	}
+}
+
+func TestSetCompactLevels(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetCompactLevels(false)
+	defer SetColorized(false)
+	defer SetColorForced(false)
+	defer SetIncludeCaller(true)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	SetIncludeCaller(false)
+	log := New(Levels.Debug)
+	SetCompactLevels(true)
+	log.Infof("[x] ", "info")
+	log.Debugf("[x] ", "debug")
+	Drain()
+	SetColorized(true)
+	SetColorForced(true)
+	log.Errorf("[x] ", "error")
+	Drain()
+
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.HasSuffix(lines[0], " I [x] info") || !strings.HasSuffix(lines[1], " D [x] debug") {
+		t.Errorf("expected compact levels but got %q", buf.String())
+	}
+	if !strings.HasSuffix(lines[2], " \x1b[31mE\x1b[0m [x] error") {
+		t.Errorf("expected a red compact level but got %q", lines[2])
+	}
+}
+
+func TestSetStrictFormat(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetStrictFormat(false)
+	defer SetIncludeCaller(true)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+	SetIncludeCaller(false)
+
+	log := New(Levels.Info)
+	log.Infof("", "got %d items", 3, "extra")
+	Drain()
+	if !strings.HasSuffix(buf.String(), "got 3 items%!(EXTRA string=extra)\n") {
+		t.Errorf("expected fmt's output when not strict but got %q", buf.String())
+	}
+
+	SetStrictFormat(true)
+	for _, tc := range []struct {
+		name, format string
+		v            []interface{}
+		want         string
+	}{
+		{"extra", "got %d items", []interface{}{3, "extra"}, `[Warn] [bad format] "got %d items" args [3 extra]`},
+		{"missing", "got %d of %d", []interface{}{3}, `[Warn] [bad format] "got %d of %d" args [3]`},
+		{"good", "got %d items", []interface{}{3}, `[Warn] got 3 items`},
+	} {
+		buf.Reset()
+		_, _, _, errsBefore := Stats()
+		log.Warnf("", tc.format, tc.v...)
+		Drain()
+		if !strings.HasSuffix(buf.String(), tc.want+"\n") {
+			t.Errorf("%s: expected %q but got %q", tc.name, tc.want, buf.String())
+		}
+		_, _, _, errs := Stats()
+		if bad := tc.name != "good"; (errs-errsBefore == 1) != bad {
+			t.Errorf("%s: expected an error to be counted only for a bad format but got %d", tc.name, errs-errsBefore)
+		}
+	}
+}
+
+func TestSetIncludeSequence(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetIncludeSequence(false)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := New(Levels.Info)
+	log.Infof("", "unnumbered")
+	Drain()
+	SetIncludeSequence(true)
+	log.Infof("", "first")
+	log.Infof("", "second")
+	Drain()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], "unnumbered") {
+		t.Fatalf("expected an unnumbered message first but got %q", buf.String())
+	}
+	first, err := strconv.ParseUint(lines[1][strings.LastIndex(lines[1], "seq=")+4:], 10, 64)
+	if err != nil || !strings.HasSuffix(lines[1], fmt.Sprintf("first seq=%d", first)) {
+		t.Fatalf("expected a numbered message but got %q", lines[1])
+	}
+	if want := fmt.Sprintf("second seq=%d", first+1); !strings.HasSuffix(lines[2], want) {
+		t.Errorf("expected %q but got %q", want, lines[2])
+	}
+}
+
+func TestSetDeferredRendering(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetDeferredRendering(false)
+	defer SetTee(nil)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+	tee := make(chan string, 1)
+	SetTee(tee)
+
+	SetDeferredRendering(true)
+	New(Levels.Info).Infof("[x] ", "deferred %d", 42)
+	Drain()
+
+	if !strings.HasSuffix(buf.String(), "deferred 42\n") {
+		t.Errorf("expected the message rendered by the writer but got %q", buf.String())
+	}
+	if got := <-tee; !strings.HasSuffix(got, "deferred 42") {
+		t.Errorf("expected the message on the tee but got %q", got)
+	}
+}
+
+func BenchmarkRendering(b *testing.B) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetDeferredRendering(false)
+	stdhdl = io.Discard
+
+	log := New(Levels.Info)
+	for _, deferred := range []bool{false, true} {
+		b.Run(fmt.Sprintf("deferred=%v", deferred), func(b *testing.B) {
+			SetDeferredRendering(deferred)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				log.Infof("[bench] ", "flow %d from %s: %v", i, "10.0.0.1", 1.5)
+			}
+			Drain()
+		})
+	}
+}
+
+func TestDrainContext(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	w := &blockingWriter{release: make(chan struct{})}
+	stdhdl = w
+
+	log := New(Levels.Info)
+	log.Infof("", "blocked")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := DrainContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected DrainContext to time out with a blocked writer but got %v", err)
+	}
+
+	drained := make(chan error)
+	go func() { drained <- DrainContext(context.Background()) }()
+	close(w.release)
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("expected DrainContext to succeed but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected DrainContext to return once the writer was unblocked")
+	}
+
+	// Polling every 10ms would take at least half a second
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		log.Infof("", "quick %d", i)
+		Drain()
+	}
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Errorf("expected Drain to return as soon as the message is written, but 50 took %v", d)
+	}
+}
+
+func TestSetRedactor(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetRedactor(nil)
+	defer SetTee(nil)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+	teeCh := make(chan string, 2)
+	SetTee(teeCh)
+
+	token := regexp.MustCompile(`token=[^& ]+`)
+	SetRedactor(func(b []byte) []byte { return token.ReplaceAll(b, []byte("token=***")) })
+	log := New(Levels.Info)
+	log.Infof("", "fetching https://api/x?token=s3cret&a=1")
+	Drain()
+
+	if !strings.HasSuffix(buf.String(), "fetching https://api/x?token=***&a=1\n") {
+		t.Errorf("expected the token to be masked but got %q", buf.String())
+	}
+	if teed := <-teeCh; !strings.HasSuffix(teed, "fetching https://api/x?token=***&a=1") {
+		t.Errorf("expected the tee to get the redacted message too but got %q", teed)
+	}
+
+	// a redactor may change the message in place
+	SetRedactor(func(b []byte) []byte { b[3] = 'S'; return b[3:] })
+	msg := &logMessage{}
+	msg.WriteString("ab secret\x00")
+	redact(msg)
+	if msg.String() != "Secret\x00" {
+		t.Errorf("expected the redacted message followed by the null terminator but got %q", msg.String())
+	}
+}
+
+func TestShortPool(t *testing.T) {
+	defer Configure(NumMessages)
+	Configure(4)
+	if err := CheckPool(); err != nil {
+		t.Fatalf("expected a full pool but got %v", err)
+	}
+
+	// a pool that can't take every message stops filling at the first
+	// that doesn't fit
+	full := freeMessages
+	freeMessages = make(chan *logMessage, 2)
+	if n := fillPool(make([]logMessage, 3)); n != 2 {
+		t.Errorf("expected 2 messages to fit but got %d", n)
+	}
+	freeMessages = full
+
+	// take a message out, as a short fill would have left it
+	<-freeMessages
+	atomic.StoreInt32(&poolFilled, 3)
+	New(Levels.Info).Infof("", "short pool")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := DrainContext(ctx); err != ErrFreeMessageUnderflow {
+		t.Errorf("expected DrainContext to finish with %v but got %v", ErrFreeMessageUnderflow, err)
+	}
+	if err := CheckPool(); err != ErrFreeMessageUnderflow {
+		t.Errorf("expected CheckPool to report %v but got %v", ErrFreeMessageUnderflow, err)
+	}
+}
+
+func TestSetOutputMode(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer func(orig func(string) error, name string) { openSyslogFn, logNameString = orig, name }(openSyslogFn, logNameString)
+	defer SetOutputMode(ModeUnset)
+	opened := 0
+	openSyslogFn = func(string) error { opened++; return nil }
+
+	stdhdl = nil
+	for _, mode := range []OutputMode{ModeStdout, ModeSocket} {
+		SetOutputMode(mode)
+		_ = SetLogName("test")
+		if opened != 0 {
+			t.Errorf("expected mode %d not to open syslog", mode)
+		}
+	}
+
+	SetOutputMode(ModeSyslog)
+	stdhdl = os.Stdout
+	_ = SetLogName("test")
+	if opened != 1 {
+		t.Error("expected the syslog mode to open syslog whatever the output")
+	}
+
+	SetOutputMode(ModeUnset)
+	_ = SetLogName("test")
+	stdhdl = nil
+	_ = SetLogName("test")
+	if opened != 2 {
+		t.Error("expected no mode to open syslog only without an output")
+	}
+}
@@ -0,0 +1,126 @@
+package logger
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Entry is a message as recorded by a MemorySink.
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Prefix  string
+	Caller  string // "file:line", or empty without a caller; see SetIncludeCaller
+	Message string
+	Fields  []Field // static fields, then the fields of the logger and the message
+}
+
+// MemorySink records the messages written by every logger as entries, for
+// tests that check what was logged without parsing the output. Messages are
+// recorded by the writer goroutine, so call Flush or Drain before looking at
+// the entries.
+type MemorySink struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// NewMemorySink returns a MemorySink that records every message written from
+// now on, in addition to the other outputs, until it is closed.
+func NewMemorySink() *MemorySink {
+	m := &MemorySink{}
+	onWriter(func() { sinks = append(sinks[:len(sinks):len(sinks)], m) })
+	return m
+}
+
+// NewTestLogger returns a new logger at level and a MemorySink recording
+// what is logged. Close the sink at the end of the test.
+func NewTestLogger(level Level) (*Logger, *MemorySink) {
+	return New(level), NewMemorySink()
+}
+
+// Close stops recording messages. The entries recorded so far are kept.
+func (m *MemorySink) Close() {
+	onWriter(func() {
+		kept := make([]sink, 0, len(sinks))
+		for _, s := range sinks {
+			if s != sink(m) {
+				kept = append(kept, s)
+			}
+		}
+		sinks = kept
+	})
+}
+
+// onWriter runs f on the writer goroutine, once every message queued before
+// has been written, so it may change the outputs while logging goes on. It
+// runs f right away if the logger is closed.
+func onWriter(f func()) {
+	done := make(chan struct{})
+	switch runOnWriter(context.Background(), func() { f(); close(done) }) {
+	case nil:
+		<-done
+	case ErrLoggerClosed:
+		f()
+	}
+}
+
+func (m *MemorySink) writeLog(msg *logMessage) error {
+	le := &msg.le
+	e := Entry{
+		Time:    msg.time,
+		Level:   le.lvl,
+		Prefix:  le.pre,
+		Message: trimNewLines(le.text()),
+	}
+	if le.lc.File != "" {
+		e.Caller = le.lc.String()
+	}
+	static := staticFieldsFor(le)
+	if n := len(static) + len(le.fields) + len(msg.meta); n > 0 {
+		e.Fields = make([]Field, 0, n)
+		e.Fields = append(append(append(e.Fields, static...), le.fields...), msg.meta...)
+	}
+
+	m.mu.Lock()
+	m.entries = append(m.entries, e)
+	m.mu.Unlock()
+	return nil
+}
+
+// Entries returns the entries recorded so far, oldest first.
+func (m *MemorySink) Entries() []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Entry{}, m.entries...)
+}
+
+// LastEntry returns the entry recorded last, and false if there is none.
+func (m *MemorySink) LastEntry() (Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.entries) == 0 {
+		return Entry{}, false
+	}
+	return m.entries[len(m.entries)-1], true
+}
+
+// EntriesAtLevel returns the entries recorded at level, oldest first.
+func (m *MemorySink) EntriesAtLevel(level Level) []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []Entry
+	for _, e := range m.entries {
+		if e.Level == level {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Reset forgets the entries recorded so far.
+func (m *MemorySink) Reset() {
+	m.mu.Lock()
+	m.entries = nil
+	m.mu.Unlock()
+}
@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMemorySink(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	stdhdl = io.Discard
+
+	log, mem := NewTestLogger(Levels.Info)
+	log = log.WithFields(Field{"service", "chf"})
+	log.Infof("[api] ", "served %d", 3)
+	log.Errorf("[api] ", "failed\n")
+	log.InfofFields("", "with fields", []Field{{"shard", 3}})
+	log.Debugf("", "filtered out")
+	Drain()
+
+	if n := len(mem.Entries()); n != 3 {
+		t.Fatalf("expected 3 entries but got %d: %+v", n, mem.Entries())
+	}
+	errs := mem.EntriesAtLevel(Levels.Error)
+	if len(errs) != 1 || errs[0].Message != "failed" || errs[0].Prefix != "[api] " {
+		t.Errorf("unexpected error entries %+v", errs)
+	}
+	if !strings.Contains(errs[0].Caller, "memsink_test.go:") {
+		t.Errorf("expected the caller of the error but got %q", errs[0].Caller)
+	}
+
+	last, ok := mem.LastEntry()
+	if !ok || last.Level != Levels.Info || last.Message != "with fields" {
+		t.Errorf("unexpected last entry %+v", last)
+	}
+	if len(last.Fields) != 2 || last.Fields[0] != (Field{"service", "chf"}) || last.Fields[1] != (Field{"shard", 3}) {
+		t.Errorf("expected the logger and message fields but got %v", last.Fields)
+	}
+
+	mem.Close()
+	log.Infof("", "after close")
+	Drain()
+	if last, _ := mem.LastEntry(); last.Message != "with fields" {
+		t.Errorf("expected nothing to be recorded after Close but got %+v", last)
+	}
+
+	mem.Reset()
+	if _, ok := mem.LastEntry(); ok {
+		t.Error("expected no entries after Reset")
+	}
+}
@@ -0,0 +1,150 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// OutputID identifies an output added with AddOutput.
+type OutputID int
+
+// DefaultOutput is the output configured with SetStdOut, SetCustomSocket
+// or syslog.
+const DefaultOutput OutputID = -1
+
+// maxRouteCache bounds the prefixes whose output is cached, so that prefixes
+// with IDs in them don't grow the cache forever.
+const maxRouteCache = 1024
+
+var (
+	// outputs added with AddOutput, indexed by OutputID
+	outputs []sink
+
+	// prefixRoutes holds the current *prefixRouting, or nil
+	prefixRoutes atomic.Value
+)
+
+// AddOutput adds an output that messages can be routed to with
+// SetPrefixOutputRouting. Messages are written to it one per line, in the
+// same format as SetStdOut. It should be called before logging starts.
+func AddOutput(w io.Writer) OutputID {
+	outputs = append(outputs, writerSink{w})
+	return OutputID(len(outputs) - 1)
+}
+
+// prefixRoute is a pattern from SetPrefixOutputRouting.
+type prefixRoute struct {
+	pattern string
+	id      OutputID
+}
+
+// prefixRouting picks the output for a message prefix. The cache is only
+// used by the writer goroutine.
+type prefixRouting struct {
+	exact map[string]OutputID
+	globs []prefixRoute // in order of precedence
+	cache map[string]OutputID
+}
+
+// SetPrefixOutputRouting routes messages to outputs by their prefix, with
+// surrounding spaces trimmed, in place of the default output. Sinks added
+// with AddSink still get every message. A pattern may use "*" to match any run of
+// characters, and no other wildcards, so prefixes like "[CHF] (access)"
+// can be used as they are. When several patterns match a prefix, a pattern
+// without a "*" wins, then the pattern with the most characters besides "*",
+// then the first in lexical order. Use the "*" pattern to pick the output
+// for messages matching nothing else; otherwise they go to DefaultOutput.
+// Passing nil turns routing off.
+func SetPrefixOutputRouting(routes map[string]OutputID) {
+	if routes == nil {
+		prefixRoutes.Store((*prefixRouting)(nil))
+		return
+	}
+
+	pr := &prefixRouting{
+		exact: map[string]OutputID{},
+		cache: map[string]OutputID{},
+	}
+	for pattern, id := range routes {
+		if strings.Contains(pattern, "*") {
+			pr.globs = append(pr.globs, prefixRoute{pattern, id})
+		} else {
+			pr.exact[pattern] = id
+		}
+	}
+	sort.Slice(pr.globs, func(i, j int) bool {
+		li := len(pr.globs[i].pattern) - strings.Count(pr.globs[i].pattern, "*")
+		lj := len(pr.globs[j].pattern) - strings.Count(pr.globs[j].pattern, "*")
+		if li != lj {
+			return li > lj
+		}
+		return pr.globs[i].pattern < pr.globs[j].pattern
+	})
+	prefixRoutes.Store(pr)
+}
+
+// route returns the output for prefix.
+func (pr *prefixRouting) route(prefix string) OutputID {
+	if id, ok := pr.cache[prefix]; ok {
+		return id
+	}
+
+	trimmed := strings.TrimSpace(prefix)
+	id, ok := pr.exact[trimmed]
+	if !ok {
+		id = DefaultOutput
+		for _, r := range pr.globs {
+			if globMatch(r.pattern, trimmed) {
+				id = r.id
+				break
+			}
+		}
+	}
+	if len(pr.cache) >= maxRouteCache {
+		pr.cache = make(map[string]OutputID, maxRouteCache)
+	}
+	pr.cache[prefix] = id
+	return id
+}
+
+// globMatch reports whether s matches pattern, where "*" matches any run of
+// characters.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 {
+			return strings.HasSuffix(s, part)
+		}
+		idx := strings.Index(s, part)
+		if idx < 0 {
+			return false
+		}
+		s = s[idx+len(part):]
+	}
+	return s == ""
+}
+
+// routeMsg returns the output the message should be written to.
+func routeMsg(msg *logMessage) OutputID {
+	pr, _ := prefixRoutes.Load().(*prefixRouting)
+	if pr == nil {
+		return DefaultOutput
+	}
+	return pr.route(msg.le.pre)
+}
+
+// writeOutput writes a message to an output added with AddOutput.
+func writeOutput(id OutputID, msg *logMessage) (err error) {
+	if id < 0 || int(id) >= len(outputs) {
+		atomic.AddUint64(&errCount, 1)
+		return fmt.Errorf("unknown log output %d", id)
+	}
+	return outputs[id].writeLog(msg)
+}
@@ -0,0 +1,182 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSetPrefixOutputRouting(t *testing.T) {
+	defer func(origstdhdl io.Writer, origOutputs []sink) {
+		stdhdl, outputs = origstdhdl, origOutputs
+		SetPrefixOutputRouting(nil)
+	}(stdhdl, outputs)
+
+	var def, access, chf, other bytes.Buffer
+	stdhdl = &def
+	accessID, chfID, otherID := AddOutput(&access), AddOutput(&chf), AddOutput(&other)
+
+	SetPrefixOutputRouting(map[string]OutputID{
+		"[CHF] (access)": accessID,
+		"[CHF]*":         chfID,
+		"[CHF] (acc*":    otherID, // longer than "[CHF]*", but the exact match wins
+		"*(db)":          otherID,
+	})
+
+	log := New(Levels.Debug)
+	log.Infof("[CHF] (access) ", "GET /")
+	log.Infof("[CHF] (accounting) ", "billed")
+	log.Infof("[CHF] ", "started")
+	log.Infof("[API] (db) ", "query")
+	log.Infof("[API] ", "unmatched")
+	Drain()
+
+	for _, tt := range []struct {
+		name string
+		buf  *bytes.Buffer
+		want []string
+	}{
+		{"access", &access, []string{"GET /"}},
+		{"chf", &chf, []string{"started"}},
+		{"other", &other, []string{"billed", "query"}},
+		{"default", &def, []string{"unmatched"}},
+	} {
+		lines := strings.Split(strings.TrimSuffix(tt.buf.String(), "\n"), "\n")
+		if len(lines) != len(tt.want) {
+			t.Errorf("%s: expected %v but got %q", tt.name, tt.want, tt.buf.String())
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.HasSuffix(lines[i], want) {
+				t.Errorf("%s: expected %q but got %q", tt.name, want, lines[i])
+			}
+		}
+	}
+}
+
+func TestPrefixOutputRoutingBadID(t *testing.T) {
+	defer SetPrefixOutputRouting(nil)
+	SetPrefixOutputRouting(map[string]OutputID{"[neg]": -2, "[big]": 99})
+
+	_, _, _, errs := Stats()
+	log := New(Levels.Info)
+	log.Infof("[neg] ", "negative")
+	log.Infof("[big] ", "out of range")
+	Drain()
+	if _, _, _, n := Stats(); n != errs+2 {
+		t.Errorf("expected both messages to count as errors but got %d", n-errs)
+	}
+}
+
+func Test_prefixRoutingCache(t *testing.T) {
+	defer SetPrefixOutputRouting(nil)
+	SetPrefixOutputRouting(map[string]OutputID{"*": DefaultOutput})
+	pr := prefixRoutes.Load().(*prefixRouting)
+
+	for i := 0; i < 3*maxRouteCache; i++ {
+		pr.route(fmt.Sprintf("[device %d] ", i))
+	}
+	if len(pr.cache) > maxRouteCache {
+		t.Errorf("expected at most %d cached prefixes but got %d", maxRouteCache, len(pr.cache))
+	}
+}
+
+func Test_globMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"[CHF]*", "[CHF] (x)", true},
+		{"[CHF]*", "[API]", false},
+		{"*(db)", "[API] (db)", true},
+		{"*(db)", "[API] (db) x", false},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXcYb", false},
+		{"ab*ba", "aba", false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.match {
+			t.Errorf("globMatch(%q, %q): expected %v but got %v", tt.pattern, tt.s, tt.match, got)
+		}
+	}
+}
+
+func TestAddSink(t *testing.T) {
+	defer func(origstdhdl io.Writer, origSinks []sink) { stdhdl, sinks = origstdhdl, origSinks }(stdhdl, sinks)
+
+	var def, extra1, extra2 bytes.Buffer
+	stdhdl = &def
+	AddSink(&extra1)
+	AddSink(&extra2)
+
+	New(Levels.Debug).Infof("[fan] ", "everywhere")
+	Drain()
+
+	for name, buf := range map[string]*bytes.Buffer{"default": &def, "extra1": &extra1, "extra2": &extra2} {
+		if !strings.Contains(buf.String(), "[Info] [fan] ") || !strings.HasSuffix(buf.String(), "everywhere\n") {
+			t.Errorf("%s: expected the message but got %q", name, buf.String())
+		}
+	}
+	if def.String() != extra1.String() || def.String() != extra2.String() {
+		t.Errorf("expected identical lines but got %q, %q and %q", def.String(), extra1.String(), extra2.String())
+	}
+}
+
+func TestSetLevelOutput(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetLevelOutput(Levels.Error, nil)
+	defer SetLevelOutput(Levels.Panic, nil)
+
+	var stdout, stderr bytes.Buffer
+	stdhdl = &stdout
+	SetLevelOutput(Levels.Error, &stderr)
+	SetLevelOutput(Levels.Panic, &stderr)
+
+	log := New(Levels.Debug)
+	log.Infof("", "info")
+	log.Errorf("", "error")
+	log.Warnf("", "warn")
+	log.TryPanicf("", "panic")
+	Drain()
+
+	if n := strings.Count(stdout.String(), "\n"); n != 2 || !strings.Contains(stdout.String(), "[Info] ") || !strings.Contains(stdout.String(), "[Warn] ") {
+		t.Errorf("expected Info and Warn on stdout but got %q", stdout.String())
+	}
+	if n := strings.Count(stderr.String(), "\n"); n != 2 || !strings.Contains(stderr.String(), "[Error] ") || !strings.Contains(stderr.String(), "[Panic] ") {
+		t.Errorf("expected Error and Panic on stderr but got %q", stderr.String())
+	}
+}
+
+func TestSetAccessOutput(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetAccessOutput(nil)
+
+	var app, access bytes.Buffer
+	stdhdl = &app
+	SetAccessOutput(&access)
+
+	log := New(Levels.Info)
+	log.Infof("", "starting")
+	log.Access(AccessFields{Method: "GET", Path: "/", Status: 200})
+	log.Warnf("", "slow")
+	Drain()
+
+	if n := strings.Count(app.String(), "\n"); n != 2 || strings.Contains(app.String(), "[Access] ") {
+		t.Errorf("expected only the application messages in the default output but got %q", app.String())
+	}
+	if n := strings.Count(access.String(), "\n"); n != 1 || !strings.Contains(access.String(), `"GET / `) {
+		t.Errorf("expected the access message in the access output but got %q", access.String())
+	}
+
+	SetAccessOutput(nil)
+	app.Reset()
+	log.Access(AccessFields{Method: "GET", Path: "/", Status: 200})
+	Drain()
+	if !strings.Contains(app.String(), "[Access] ") {
+		t.Errorf("expected access messages back in the default output but got %q", app.String())
+	}
+}
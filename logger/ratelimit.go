@@ -0,0 +1,164 @@
+package logger
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// summaryInterval is how often the writer goroutine emits the summaries of
+// messages suppressed by rate limiting.
+const summaryInterval = time.Second
+
+// evictedBucket marks the start of a bucket that flush is removing, so that
+// messages take a new one instead.
+const evictedBucket = -1
+
+var (
+	// rateLimiters are all limiters in use, so the writer can flush their
+	// suppressed counts
+	rateLimiters   = map[*rateLimiter]struct{}{}
+	rateLimitersMu sync.Mutex
+)
+
+// rateKey identifies identical messages. It uses the format string rather
+// than the rendered message so that varying arguments still collapse.
+type rateKey struct {
+	lvl Level
+	pre string
+	fmt string
+}
+
+// rateBucket counts messages for a key within the current window.
+type rateBucket struct {
+	start      int64  // start of the current window, in unix nanoseconds
+	count      uint64 // messages seen in the current window
+	suppressed uint64 // messages suppressed since the last summary
+	lc         logCaller
+}
+
+// rateLimiter suppresses messages repeated more than burst times per window.
+type rateLimiter struct {
+	window  int64
+	burst   uint64
+	retired int32    // set once the logger no longer uses this limiter
+	buckets sync.Map // rateKey -> *rateBucket
+}
+
+// SetRateLimit suppresses identical messages, keyed on their level, prefix
+// and format string, beyond burst per window. The number of suppressed
+// messages is periodically logged as a summary by the writer goroutine. A
+// zero window or burst turns rate limiting off.
+func (l *Logger) SetRateLimit(window time.Duration, burst int) {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+
+	if l.limiter != nil {
+		// the writer unregisters it once its remaining summaries are flushed
+		atomic.StoreInt32(&l.limiter.retired, 1)
+		l.limiter = nil
+	}
+	if window <= 0 || burst <= 0 {
+		return
+	}
+
+	l.limiter = &rateLimiter{window: int64(window), burst: uint64(burst)}
+	rateLimiters[l.limiter] = struct{}{}
+}
+
+// allow reports whether a message should be logged, counting it as
+// suppressed if it shouldn't.
+func (rl *rateLimiter) allow(lvl Level, prefix, format string, lc logCaller) bool {
+	key := rateKey{lvl, prefix, format}
+	now := time.Now().UnixNano()
+
+	for {
+		bucket := rl.bucket(key, now, lc)
+		start := atomic.LoadInt64(&bucket.start)
+		if start == evictedBucket {
+			runtime.Gosched() // flush is removing it
+			continue
+		}
+		if now-start >= rl.window {
+			if !atomic.CompareAndSwapInt64(&bucket.start, start, now) {
+				continue // another message started a window, or flush evicted it
+			}
+			atomic.StoreUint64(&bucket.count, 0)
+		}
+		if atomic.AddUint64(&bucket.count, 1) <= rl.burst {
+			return true
+		}
+
+		atomic.AddUint64(&bucket.suppressed, 1)
+		if atomic.LoadInt64(&bucket.start) == evictedBucket {
+			// evicted after the window check: move the count over to the new
+			// bucket, unless flush already summarized it
+			if n := atomic.SwapUint64(&bucket.suppressed, 0); n > 0 {
+				atomic.AddUint64(&rl.bucket(key, now, lc).suppressed, n)
+			}
+		}
+		return false
+	}
+}
+
+// bucket returns the bucket of key, adding one if there is none.
+func (rl *rateLimiter) bucket(key rateKey, now int64, lc logCaller) *rateBucket {
+	b, ok := rl.buckets.Load(key)
+	if !ok {
+		b, _ = rl.buckets.LoadOrStore(key, &rateBucket{start: now, lc: lc})
+	}
+	return b.(*rateBucket)
+}
+
+// flushSuppressed writes a summary message for every key that had messages
+// suppressed since the last flush. It runs on the writer goroutine, which
+// also flushes once more when it stops.
+func flushSuppressed() {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+
+	now := time.Now().UnixNano()
+	for rl := range rateLimiters {
+		rl.flush(now)
+		if atomic.LoadInt32(&rl.retired) == 1 {
+			delete(rateLimiters, rl)
+		}
+	}
+}
+
+// flush writes the summaries of the limiter and drops the buckets of keys
+// not seen for a whole window, so that keys that come and go, such as
+// prefixes with IDs in them, don't use memory forever.
+func (rl *rateLimiter) flush(now int64) {
+	rl.buckets.Range(func(k, b interface{}) bool {
+		key, bucket := k.(rateKey), b.(*rateBucket)
+		start := atomic.LoadInt64(&bucket.start)
+		if now-start >= 2*rl.window && atomic.CompareAndSwapInt64(&bucket.start, start, evictedBucket) {
+			rl.buckets.Delete(key) // the next message starts a new bucket
+		}
+		if n := atomic.SwapUint64(&bucket.suppressed, 0); n > 0 {
+			writeSummary(key, bucket.lc, n)
+		}
+		return true
+	})
+}
+
+// writeSummary writes a suppression summary like any other message, so it
+// is teed, numbered and redacted the same way. It must run on the writer
+// goroutine, and takes a spare message rather than one of the fixed set,
+// which may all be queued. Summaries are not counted as logs in Stats.
+func writeSummary(key rateKey, lc logCaller, n uint64) {
+	atomic.AddInt32(&bytesInFlight, 1) // freeMsg gives it back
+	msg := bytesMessages.Get().(*logMessage)
+	msg.le = logEntry{
+		lvl:  key.lvl,
+		pre:  key.pre,
+		fmt:  "%s ... (suppressed %d identical messages)",
+		fmtV: []interface{}{trimNewLines(key.fmt), n},
+		lc:   lc,
+		tee:  true,
+	}
+	stamp(msg)
+	writeMessage(msg) // renders and tees it
+}
@@ -0,0 +1,130 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetRateLimit(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := New(Levels.Debug)
+	log.SetRateLimit(time.Hour, 2)
+	defer log.SetRateLimit(0, 0)
+
+	for i := 0; i < 10; i++ {
+		log.Errorf("[rate] ", "connection %d failed", i)
+	}
+	log.Infof("[rate] ", "connection %d failed", 0) // a different level is a different key
+	Drain()
+
+	if n := strings.Count(buf.String(), "failed\n"); n != 3 {
+		t.Fatalf("expected 3 lines before the summary but got %d: %q", n, buf.String())
+	}
+
+	// the writer also flushes every summaryInterval, so look for the summary
+	// in everything written
+	flush := func() {
+		if err := runOnWriter(context.Background(), flushSuppressed); err != nil {
+			t.Fatal(err)
+		}
+		Drain()
+	}
+	flush()
+	if !strings.Contains(buf.String(), "[Error] [rate] ") ||
+		!strings.Contains(buf.String(), "connection %d failed ... (suppressed 8 identical messages)") {
+		t.Errorf("expected a summary of 8 suppressed messages but got %q", buf.String())
+	}
+
+	flush()
+	if n := strings.Count(buf.String(), "suppressed"); n != 1 {
+		t.Errorf("expected no summary once flushed but got %q", buf.String())
+	}
+}
+
+func TestRateLimitSummaryTee(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetTee(nil)
+	defer SetIncludeSequence(false)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+	tee := make(chan string, 10)
+	SetTee(tee)
+	SetIncludeSequence(true)
+
+	log := New(Levels.Debug)
+	log.SetRateLimit(time.Hour, 1)
+	defer log.SetRateLimit(0, 0)
+	log.Errorf("[rate] ", "timeout")
+	log.Errorf("[rate] ", "timeout")
+	if err := runOnWriter(context.Background(), flushSuppressed); err != nil {
+		t.Fatal(err)
+	}
+	Drain()
+
+	if !strings.Contains(buf.String(), "(suppressed 1 identical messages) seq=") {
+		t.Errorf("expected the summary to be numbered like other messages but got %q", buf.String())
+	}
+	if teed := len(tee); teed != 2 {
+		t.Errorf("expected the message and its summary to be teed but got %d lines", teed)
+	}
+}
+
+func TestRateLimitSummaryClose(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer Reinit()
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := New(Levels.Debug)
+	log.SetRateLimit(time.Hour, 1)
+	defer log.SetRateLimit(0, 0)
+	for i := 0; i < 5; i++ {
+		log.Errorf("[rate] ", "disk full")
+	}
+	if err := Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "disk full ... (suppressed 4 identical messages)") {
+		t.Errorf("expected the last summary to be written on Close but got %q", buf.String())
+	}
+}
+
+func Test_rateLimiterFlush(t *testing.T) {
+	rl := &rateLimiter{window: int64(10 * time.Millisecond), burst: 1}
+	rl.allow(Levels.Info, "[device 1] ", "x", logCaller{})
+	now := time.Now().UnixNano()
+
+	rl.flush(now)
+	if _, ok := rl.buckets.Load(rateKey{Levels.Info, "[device 1] ", "x"}); !ok {
+		t.Error("expected a recent key to be kept")
+	}
+	rl.flush(now + int64(time.Second))
+	if _, ok := rl.buckets.Load(rateKey{Levels.Info, "[device 1] ", "x"}); ok {
+		t.Error("expected an idle key to be dropped")
+	}
+	if !rl.allow(Levels.Info, "[device 1] ", "x", logCaller{}) {
+		t.Error("expected a dropped key to start a new bucket")
+	}
+}
+
+func TestSetRateLimitWindow(t *testing.T) {
+	rl := &rateLimiter{window: int64(10 * time.Millisecond), burst: 1}
+	if !rl.allow(Levels.Info, "", "x", logCaller{}) {
+		t.Fatal("expected first message to be allowed")
+	}
+	if rl.allow(Levels.Info, "", "x", logCaller{}) {
+		t.Fatal("expected second message to be suppressed")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !rl.allow(Levels.Info, "", "x", logCaller{}) {
+		t.Fatal("expected a message in a new window to be allowed")
+	}
+}
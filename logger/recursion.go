@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sync/atomic"
+)
+
+var (
+	// writerGoid is the goroutine ID of the writer goroutine, writerEntry
+	// is the entry PC of logWriter, at the bottom of its stack, and
+	// writerBusy is 1 while it is writing rather than waiting for messages;
+	// atomic
+	writerGoid  uint64
+	writerEntry uintptr
+	writerBusy  int32
+
+	// recursionCount is the number of messages logged from the writer
+	// goroutine, which are written to recursionOut instead of being queued
+	recursionCount uint64
+	recursionOut   io.Writer = os.Stderr
+)
+
+// Recursions returns the number of messages logged from the writer
+// goroutine since startup, such as by an output or hook that logs its own
+// errors. Queuing them could deadlock the writer, or feed it forever, so they
+// are written straight to stderr instead. They are still counted as logs in
+// Stats.
+func Recursions() uint64 {
+	return atomic.LoadUint64(&recursionCount)
+}
+
+// inWriter reports whether it is called from the writer goroutine while it
+// writes, which is when logWriter is at the bottom of the stack. Walking the
+// stack takes a while, so it is only done while the writer is busy, and the
+// slower goroutine ID is only looked up for stacks too deep to walk.
+func inWriter() bool {
+	if atomic.LoadInt32(&writerBusy) == 0 {
+		return false
+	}
+
+	var pcs [64]uintptr
+	n := runtime.Callers(2, pcs[:])
+	if n == len(pcs) {
+		return goroutineID() == atomic.LoadUint64(&writerGoid)
+	}
+	entry := atomic.LoadUintptr(&writerEntry)
+	for i := n - 1; i >= 0 && i >= n-3; i-- { // logWriter, then runtime.goexit
+		if f := runtime.FuncForPC(pcs[i] - 1); f != nil && f.Entry() == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// markWriter records the goroutine logWriter runs on, for inWriter; it
+// must be called from logWriter.
+func markWriter() {
+	var pc [1]uintptr
+	runtime.Callers(2, pc[:])
+	atomic.StoreUintptr(&writerEntry, runtime.FuncForPC(pc[0]-1).Entry())
+	atomic.StoreUint64(&writerGoid, goroutineID())
+}
+
+// writeRecursive writes a message logged from the writer goroutine to
+// recursionOut as a bare line of its level, prefix and message text.
+func writeRecursive(le *logEntry) error {
+	atomic.AddUint64(&recursionCount, 1)
+	line := make([]byte, 0, 128)
+	line = append(line, levelFmt(le.lvl)...)
+	line = append(line, le.pre...)
+	line = append(line, trimNewLines(le.text())...)
+	_, err := recursionOut.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,84 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recursiveWriter is an output that logs every write it gets.
+type recursiveWriter struct {
+	bytes.Buffer
+	log *Logger
+}
+
+func (w *recursiveWriter) Write(p []byte) (int, error) {
+	w.log.Errorf("[sink] ", "writing %d bytes", len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestLogRecursion(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer func(out io.Writer) { recursionOut = out }(recursionOut)
+	defer Configure(NumMessages)
+	defer SetBlockOnFull(false)
+
+	// with a single message that the writer holds while writing, queuing
+	// the sink's message would block forever
+	Configure(1)
+	SetBlockOnFull(true)
+	log := New(Levels.Info)
+	w := &recursiveWriter{log: log}
+	stderr := bytes.Buffer{}
+	stdhdl, recursionOut = w, &stderr
+
+	before := Recursions()
+	log.Infof("", "message")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := DrainContext(ctx); err != nil {
+		t.Fatalf("expected the writer not to deadlock but got %v", err)
+	}
+
+	if !strings.HasSuffix(w.String(), "message\n") || strings.Contains(w.String(), "[sink]") {
+		t.Errorf("expected only the original message in the output but got %q", w.String())
+	}
+	if !strings.HasPrefix(stderr.String(), "[Error] [sink] writing ") {
+		t.Errorf("expected the sink's message on stderr but got %q", stderr.String())
+	}
+	if n := Recursions() - before; n != 1 {
+		t.Errorf("expected 1 recursion but got %d", n)
+	}
+}
+
+func Test_inWriter(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	w := &blockingWriter{release: make(chan struct{})}
+	stdhdl = w
+
+	log := New(Levels.Info)
+	log.Infof("", "keeps the writer busy")
+	for atomic.LoadInt32(&writerBusy) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if inWriter() {
+		t.Error("expected another goroutine not to be taken for the writer")
+	}
+	inWriterOnWriter := make(chan bool, 1)
+	deep := func() { inWriterOnWriter <- inWriter() }
+	for i := 0; i < 80; i++ { // deeper than inWriter walks
+		deep = func(f func()) func() { return func() { f() } }(deep)
+	}
+	close(w.release)
+	if err := runOnWriter(context.Background(), deep); err != nil {
+		t.Fatal(err)
+	}
+	if !<-inWriterOnWriter {
+		t.Error("expected the writer goroutine to be found with a deep stack")
+	}
+	Drain()
+}
@@ -0,0 +1,145 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+)
+
+var (
+	// loggerOutputs are the writers set with Logger.SetOutput, so that
+	// ReopenOutput, Flush and Close reach them too
+	loggerOutputs   []io.Writer
+	loggerOutputsMu sync.Mutex
+)
+
+// Reopener is implemented by outputs backed by a file, which can reopen it
+// by path after it was renamed, for instance by logrotate.
+type Reopener interface {
+	Reopen() error
+}
+
+// ReopenOutput reopens every output that implements Reopener: the writer set
+// with SetWriter, the outputs added with SetLevelOutput, AddSink and
+// AddOutput, and those of Logger.SetOutput. It runs on the writer goroutine once the messages queued before
+// it are written, so no message is split between the old and new files. It
+// is a no-op for stdout, syslog and the custom socket. The first error is
+// returned, but every output is reopened.
+func ReopenOutput() error {
+	errc := make(chan error, 1)
+	if err := runOnWriter(context.Background(), func() { errc <- reopenOutputs() }); err != nil {
+		return err
+	}
+	return <-errc
+}
+
+// ReopenOnSignal calls ReopenOutput whenever the process receives one of
+// sigs, typically syscall.SIGHUP. Failures are logged.
+func ReopenOnSignal(sigs ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		for range c {
+			if err := ReopenOutput(); err != nil {
+				LogNoTee(Levels.Error, "[meta log]", "reopening log outputs: %v", err)
+			}
+		}
+	}()
+}
+
+// outputWriters returns the io.Writers messages are written to; it must run
+// on the writer goroutine.
+func outputWriters() []io.Writer {
+	ws := []io.Writer{stdhdl}
+	for _, w := range levelOutputs {
+		ws = append(ws, w)
+	}
+	for _, s := range append(append([]sink{}, sinks...), outputs...) {
+		if s, ok := s.(writerSink); ok {
+			ws = append(ws, s.w)
+		}
+	}
+
+	loggerOutputsMu.Lock()
+	defer loggerOutputsMu.Unlock()
+	return append(ws, loggerOutputs...)
+}
+
+// addLoggerOutput adds w to loggerOutputs, once.
+func addLoggerOutput(w io.Writer) {
+	if !reflect.TypeOf(w).Comparable() {
+		return // it couldn't be told apart from the ones already added
+	}
+
+	loggerOutputsMu.Lock()
+	defer loggerOutputsMu.Unlock()
+	for _, o := range loggerOutputs {
+		if o == w {
+			return
+		}
+	}
+	loggerOutputs = append(loggerOutputs, w)
+}
+
+// reopenOutputs reopens the outputs that implement Reopener; it must run on
+// the writer goroutine.
+func reopenOutputs() (err error) {
+	for _, w := range outputWriters() {
+		if r, ok := w.(Reopener); ok {
+			if rerr := r.Reopen(); rerr != nil && err == nil {
+				err = rerr
+			}
+		}
+	}
+	return
+}
+
+// FileWriter is an io.Writer to a file that can be reopened by path, so
+// logrotate can rename it; see ReopenOutput.
+type FileWriter struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileWriter opens path for appending, creating it if needed.
+func NewFileWriter(path string) (*FileWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileWriter{path: path, file: f}, nil
+}
+
+// Write writes p to the file.
+func (w *FileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Write(p)
+}
+
+// Reopen closes the file and opens path again. If opening fails, writes go
+// on to the old file.
+func (w *FileWriter) Reopen() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.file.Close()
+	w.file = f
+	return nil
+}
+
+// Close closes the file.
+func (w *FileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReopenOutput(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+
+	dir, err := os.MkdirTemp("", "golog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	w, err := NewFileWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	SetWriter(w)
+
+	log := New(Levels.Info)
+	log.Infof("", "before")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReopenOutput(); err != nil {
+		t.Fatal(err)
+	}
+	log.Infof("", "after")
+	if err := Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{path + ".1": "before", path: "after"} {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"); len(lines) != 1 || !strings.HasSuffix(lines[0], want) {
+			t.Errorf("expected %s to hold only %q but got %q", name, want, b)
+		}
+	}
+}
+
+func TestReopenLoggerOutput(t *testing.T) {
+	defer func(orig []io.Writer) { loggerOutputs = orig }(loggerOutputs)
+	dir, err := os.MkdirTemp("", "golog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "plugin.log")
+	w, err := NewFileWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	log := New(Levels.Info)
+	log.SetOutput(w)
+	log.SetOutput(w) // added once
+	log.Infof("", "before")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReopenOutput(); err != nil {
+		t.Fatal(err)
+	}
+	log.Infof("", "after")
+	if err := Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), "after\n") || strings.Contains(string(b), "before") {
+		t.Errorf("expected the logger output to be reopened but got %q", b)
+	}
+	n := 0
+	for _, o := range outputWriters() {
+		if o == io.Writer(w) {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("expected the logger output to be listed once but got %d", n)
+	}
+}
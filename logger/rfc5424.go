@@ -0,0 +1,222 @@
+package logger
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sdID is the SD-ID of the structured data element holding message fields.
+// 32473 is the private enterprise number reserved for documentation.
+const sdID = "fields@32473"
+
+var (
+	// syslogLegacyFormat keeps the "<PRI>message" custom socket format
+	syslogLegacyFormat bool
+
+	// syslogHostname and syslogAppName override the RFC 5424 header fields
+	syslogHostname, syslogAppName string
+
+	// hostname is looked up once, at startup
+	hostname, _ = os.Hostname()
+)
+
+// SetSyslogLegacyFormat makes the custom socket write messages as
+// "<PRI>message", as it used to, instead of full RFC 5424 frames.
+func SetSyslogLegacyFormat(legacy bool) {
+	syslogLegacyFormat = legacy
+}
+
+// SetSyslogHostname sets the HOSTNAME of RFC 5424 frames, which is
+// os.Hostname() by default.
+func SetSyslogHostname(name string) {
+	syslogHostname = name
+}
+
+// SetSyslogAppName sets the APP-NAME of RFC 5424 frames, which is the name
+// set with SetLogName by default.
+func SetSyslogAppName(name string) {
+	syslogAppName = name
+}
+
+// appendRFC5424 appends msg to b as an RFC 5424 syslog message:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [STRUCTURED-DATA] MSG
+//
+// The message fields go in a single structured data element.
+func appendRFC5424(b []byte, pri int, msg *logMessage) []byte {
+	host, app := syslogHostname, syslogAppName
+	if host == "" {
+		host = hostname
+	}
+	if app == "" {
+		app = logNameString
+	}
+
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(pri), 10)
+	b = append(b, ">1 "...)
+	b = msg.time.AppendFormat(b, "2006-01-02T15:04:05.000000Z07:00")
+	b = append(b, ' ')
+	b = appendHeaderField(b, host, 255)
+	b = append(b, ' ')
+	b = appendHeaderField(b, app, 48)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(os.Getpid()), 10)
+	b = append(b, " - "...) // no MSGID
+	b = appendStructuredData(b, staticFieldsFor(&msg.le), msg.le.fields, msg.meta)
+	b = append(b, ' ')
+	return append(b, socketPayload(msg)...)
+}
+
+// appendHeaderField appends a header field, which must be printable ASCII
+// without spaces and at most max long, or "-" when empty.
+func appendHeaderField(b []byte, s string, max int) []byte {
+	if s == "" {
+		return append(b, '-')
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c > '~' {
+			c = '_'
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// appendStructuredData appends fields as a structured data element, or "-"
+// if there are none.
+func appendStructuredData(b []byte, fieldSets ...[]Field) []byte {
+	n := 0
+	for _, fields := range fieldSets {
+		n += len(fields)
+	}
+	if n == 0 {
+		return append(b, '-')
+	}
+
+	b = append(b, '[')
+	b = append(b, sdID...)
+	for _, fields := range fieldSets {
+		for _, f := range fields {
+			b = append(b, ' ')
+			b = appendSDName(b, f.Key)
+			b = append(b, `="`...)
+			b = appendSDValue(b, fieldString(f.Val))
+			b = append(b, '"')
+		}
+	}
+	return append(b, ']')
+}
+
+// appendSDName appends a PARAM-NAME: up to 32 printable ASCII characters
+// other than '=', ' ', ']' and '"'.
+func appendSDName(b []byte, name string) []byte {
+	if name == "" {
+		return append(b, '_')
+	}
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c > '~' || strings.IndexByte(`="]`, c) >= 0 {
+			c = '_'
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// appendSDValue appends a PARAM-VALUE, escaping '"', '\' and ']'.
+func appendSDValue(b []byte, value string) []byte {
+	buf := bytes.NewBuffer(b)
+	for _, r := range value {
+		switch r {
+		case '"', '\\', ']':
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(r)
+	}
+	return buf.Bytes()
+}
+
+// SyslogFraming is how messages are delimited on the custom socket.
+type SyslogFraming int
+
+const (
+	// SyslogFramingAuto uses octet counting on stream sockets (TCP and unix)
+	// and a datagram per message otherwise.
+	SyslogFramingAuto SyslogFraming = iota
+	// SyslogFramingDatagram writes each message as is, null terminated.
+	SyslogFramingDatagram
+	// SyslogFramingOctetCounted prefixes each message with its length, as in
+	// RFC 6587: "<len> <msg>".
+	SyslogFramingOctetCounted
+)
+
+var syslogFraming = SyslogFramingAuto
+
+// SetSyslogFraming forces the framing of messages on the custom socket,
+// instead of choosing it from the socket network.
+func SetSyslogFraming(mode SyslogFraming) {
+	syslogFraming = mode
+}
+
+// octetCounted reports whether messages on the custom socket are framed by
+// octet counting.
+func octetCounted() bool {
+	switch syslogFraming {
+	case SyslogFramingDatagram:
+		return false
+	case SyslogFramingOctetCounted:
+		return true
+	}
+	return strings.HasPrefix(customSockNetwork, "tcp") || customSockNetwork == "unix"
+}
+
+// SocketFormat is how messages are written to the custom socket.
+type SocketFormat int
+
+const (
+	// SocketFormatSyslog writes syslog messages, framed as set with
+	// SetSyslogFraming.
+	SocketFormatSyslog SocketFormat = iota
+	// SocketFormatRaw writes each message as it is rendered, followed by a
+	// newline, without a syslog header or null terminator. With JSON
+	// messages that is newline delimited JSON, which collectors such as
+	// Vector and Fluent Bit read directly.
+	SocketFormatRaw
+)
+
+var socketFormat = SocketFormatSyslog
+
+// SetSocketFormat sets how messages are written to the custom socket. It is
+// SocketFormatSyslog by default.
+func SetSocketFormat(f SocketFormat) {
+	socketFormat = f
+}
+
+// rawSocketFrame returns the frame of a message for SocketFormatRaw.
+func rawSocketFrame(msg *logMessage) []byte {
+	b := bytes.TrimRight(msg.Bytes()[:msg.Len()-1], "\n")
+	return append(append(make([]byte, 0, len(b)+1), b...), '\n')
+}
+
+// frameSyslog returns the frame of a message for the custom socket. Octet
+// counted frames drop the null terminator, which stream collectors don't
+// expect.
+func frameSyslog(frame []byte) []byte {
+	if !octetCounted() {
+		return frame
+	}
+	frame = bytes.TrimSuffix(frame, []byte{0})
+	b := strconv.AppendInt(make([]byte, 0, len(frame)+8), int64(len(frame)), 10)
+	b = append(b, ' ')
+	return append(b, frame...)
+}
@@ -0,0 +1,173 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_appendRFC5424(t *testing.T) {
+	defer func(name string) { logNameString = name }(logNameString)
+	defer SetSyslogHostname("")
+	defer SetSyslogAppName("")
+
+	tm := time.Date(2021, 5, 4, 3, 2, 1, 123456789, time.UTC)
+	msg := &logMessage{time: tm, le: logEntry{fields: []Field{{"trace id", `a"b]c`}, {"n", 3}}}}
+	msg.WriteString("[Info] hello\x00")
+
+	logNameString = "chf"
+	SetSyslogHostname("host 1")
+	got := string(appendRFC5424(nil, 14, msg))
+	want := fmt.Sprintf(`<14>1 2021-05-04T03:02:01.123456Z host_1 chf %d - [fields@32473 trace_id="a\"b\]c" n="3"] [Info] hello`+"\x00", os.Getpid())
+	if got != want {
+		t.Errorf("expected\n%q but got\n%q", want, got)
+	}
+
+	SetSyslogAppName("app")
+	msg.le.fields = nil
+	got = string(appendRFC5424(nil, 11, msg))
+	want = fmt.Sprintf("<11>1 2021-05-04T03:02:01.123456Z host_1 app %d - - [Info] hello\x00", os.Getpid())
+	if got != want {
+		t.Errorf("expected\n%q but got\n%q", want, got)
+	}
+}
+
+func TestSyslogFraming(t *testing.T) {
+	defer func(sock net.Conn, network string) {
+		customSock, customSockNetwork = sock, network
+		atomic.StoreInt32(&customSockConnected, 0)
+		SetSyslogFraming(SyslogFramingAuto)
+		SetSyslogLegacyFormat(false)
+	}(customSock, customSockNetwork)
+	SetSyslogLegacyFormat(true)
+
+	msg := &logMessage{level: 3}
+	msg.WriteString("hello\x00")
+
+	tests := []struct {
+		network string
+		framing SyslogFraming
+		want    string
+	}{
+		{"tcp", SyslogFramingAuto, "9 <11>hello"},
+		{"unix", SyslogFramingAuto, "9 <11>hello"},
+		{"udp", SyslogFramingAuto, "<11>hello\x00"},
+		{"tcp", SyslogFramingDatagram, "<11>hello\x00"},
+		{"udp", SyslogFramingOctetCounted, "9 <11>hello"},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		customSock, customSockNetwork = client, tt.network
+		atomic.StoreInt32(&customSockConnected, 1)
+		SetSyslogFraming(tt.framing)
+
+		received := make(chan string)
+		go func() {
+			buf := make([]byte, 1024)
+			n, _ := server.Read(buf)
+			received <- string(buf[:n])
+		}()
+		if err := writeCustomSocket(msg); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-received; got != tt.want {
+			t.Errorf("%s/%d: expected %q but got %q", tt.network, tt.framing, tt.want, got)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestSyslogJSONPayload(t *testing.T) {
+	defer func(sock net.Conn, network string) {
+		customSock, customSockNetwork = sock, network
+		atomic.StoreInt32(&customSockConnected, 0)
+		SetSyslogLegacyFormat(false)
+	}(customSock, customSockNetwork)
+	defer func(f func(*logMessage) error, json bool) { format, sendJSON = f, json }(format, sendJSON)
+	format, sendJSON = asJSON, true
+	SetSyslogLegacyFormat(true)
+
+	msg := &logMessage{le: logEntry{lvl: Levels.Error, pre: "[a\n\"b\"] ", fmt: "boom"}}
+	if err := render(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, network := range []string{"udp", "tcp"} {
+		client, server := net.Pipe()
+		customSock, customSockNetwork = client, network
+		atomic.StoreInt32(&customSockConnected, 1)
+
+		received := make(chan []byte)
+		go func() {
+			buf := make([]byte, 1024)
+			n, _ := server.Read(buf)
+			received <- buf[:n]
+		}()
+		if err := writeCustomSocket(msg); err != nil {
+			t.Fatal(err)
+		}
+		got := <-received
+		client.Close()
+		server.Close()
+
+		// skip the octet count and <PRI>
+		object := got[bytes.IndexByte(got, '>')+1:]
+		if bytes.IndexByte(object, 0) >= 0 || bytes.Count(object, []byte("\n")) != 1 || !bytes.HasSuffix(object, []byte("}\n")) {
+			t.Errorf("%s: expected one newline terminated object but got %q", network, got)
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal(object, &entry); err != nil || entry["prefix"] != "[a\n\"b\"]" {
+			t.Errorf("%s: expected an escaped prefix but got %q: %v", network, got, err)
+		}
+	}
+}
+
+func TestSetSocketFormat(t *testing.T) {
+	defer func(network string) { customSockNetwork = network }(customSockNetwork)
+	defer func(f func(*logMessage) error, json bool) { format, sendJSON = f, json }(format, sendJSON)
+	defer SetSocketFormat(SocketFormatSyslog)
+	defer SetSyslogLegacyFormat(false)
+	format, sendJSON = asJSON, true
+	customSockNetwork = "tcp"
+	SetSyslogLegacyFormat(true)
+
+	msg := &logMessage{
+		time:  time.Date(2021, 5, 4, 3, 2, 1, 0, time.UTC),
+		level: logInfo,
+		le:    logEntry{lvl: Levels.Info, fmt: "hi"},
+	}
+	if err := renderBody(msg); err != nil {
+		t.Fatal(err)
+	}
+	object := `{"time":"2021-05-04T03:02:01Z","name":"` + logNameString + `","level":"Info","prefix":"","message":"hi"}`
+
+	tests := []struct {
+		format SocketFormat
+		want   string
+	}{
+		{SocketFormatSyslog, fmt.Sprintf("%d <14>%s\n", len(object)+5, object)},
+		{SocketFormatRaw, object + "\n"},
+	}
+	for _, tt := range tests {
+		SetSocketFormat(tt.format)
+		if got := string(customSocketFrame(msg)); got != tt.want {
+			t.Errorf("format %d: expected %q but got %q", tt.format, tt.want, got)
+		}
+	}
+
+	format, sendJSON = asString, false
+	msg.Reset()
+	if err := renderBody(msg); err != nil {
+		t.Fatal(err)
+	}
+	SetSocketFormat(SocketFormatRaw)
+	if got, want := string(customSocketFrame(msg)), "[Info] hi\n"; got != want {
+		t.Errorf("expected the raw string message %q but got %q", want, got)
+	}
+}
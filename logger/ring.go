@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+)
+
+// RingBuffer is an io.Writer keeping the last lines written to it, for
+// instance to serve recent messages from a debug HTTP handler. Add it with
+// AddSink to get a copy of every message.
+type RingBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int  // index of the oldest line once full
+	full  bool // whether lines has wrapped around
+}
+
+// NewRingBuffer returns a RingBuffer keeping the last n lines.
+func NewRingBuffer(n int) *RingBuffer {
+	if n <= 0 {
+		n = 1
+	}
+	return &RingBuffer{lines: make([]string, n)}
+}
+
+// Write stores p as one line, without its trailing newline, replacing the
+// oldest line if the ring is full.
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+
+	r.mu.Lock()
+	r.lines[r.next] = line
+	r.next++
+	if r.next == len(r.lines) {
+		r.next, r.full = 0, true
+	}
+	r.mu.Unlock()
+	return len(p), nil
+}
+
+// Dump returns the lines in the ring, oldest first.
+func (r *RingBuffer) Dump() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	return append(append(make([]string, 0, len(r.lines)), r.lines[r.next:]...), r.lines[:r.next]...)
+}
@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRingBuffer(t *testing.T) {
+	r := NewRingBuffer(3)
+	if got := r.Dump(); len(got) != 0 {
+		t.Errorf("expected an empty ring but got %q", got)
+	}
+
+	for i := 1; i <= 2; i++ {
+		fmt.Fprintf(r, "line %d\n", i)
+	}
+	if got := strings.Join(r.Dump(), ","); got != "line 1,line 2" {
+		t.Errorf("unexpected lines %q", got)
+	}
+
+	for i := 3; i <= 5; i++ {
+		fmt.Fprintf(r, "line %d\n", i)
+	}
+	if got := strings.Join(r.Dump(), ","); got != "line 3,line 4,line 5" {
+		t.Errorf("expected the last 3 lines but got %q", got)
+	}
+}
+
+func TestRingBufferSink(t *testing.T) {
+	defer func(s []sink) { sinks = s }(sinks)
+	r := NewRingBuffer(10)
+	AddSink(r)
+
+	log := New(Levels.Info)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // dump while logging
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			r.Dump()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		log.Infof("", "message %d", i)
+	}
+	wg.Wait()
+	Drain()
+
+	got := r.Dump()
+	if len(got) != 10 || !strings.HasSuffix(got[9], "message 19") || !strings.HasSuffix(got[0], "message 10") {
+		t.Errorf("expected the last 10 messages but got %q", got)
+	}
+}
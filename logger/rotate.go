@@ -0,0 +1,173 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// RotatingFileWriter is an io.Writer to a file that is rotated once it grows
+// past a size limit, keeping a number of older files as backups. Backups are
+// named after the file with a ".1" suffix for the newest, ".2" for the one
+// before, and so on, plus ".gz" when compressed. Use it as the output with
+// SetWriter or AddSink.
+type RotatingFileWriter struct {
+	path     string
+	maxBytes int64
+	backups  int
+	compress bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFileWriter opens path for appending, creating it if needed. The
+// file is rotated before a write would take it past maxBytes, keeping up to
+// backups older files, gzipped if compress is set.
+func NewRotatingFileWriter(path string, maxBytes int64, backups int, compress bool) (*RotatingFileWriter, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("invalid max size %d for %s", maxBytes, path)
+	}
+
+	w := &RotatingFileWriter{path: path, maxBytes: maxBytes, backups: backups, compress: compress}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the file at w.path and picks up its current size.
+func (w *RotatingFileWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size = f, info.Size()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p would take it past the
+// size limit. If rotating fails, p is written to the current file anyway.
+func (w *RotatingFileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			atomic.AddUint64(&errCount, 1)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Reopen closes the file and opens it again by path, for when it was
+// rotated externally. If opening fails, writes go on to the old file.
+func (w *RotatingFileWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	old := w.file
+	if err := w.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// Close closes the file.
+func (w *RotatingFileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// backupName returns the name of the n-th backup.
+func (w *RotatingFileWriter) backupName(n int) string {
+	name := fmt.Sprintf("%s.%d", w.path, n)
+	if w.compress {
+		name += ".gz"
+	}
+	return name
+}
+
+// rotate moves the current file to the first backup and opens a new one. On
+// failure the current file stays open, so writes can carry on.
+func (w *RotatingFileWriter) rotate() error {
+	if w.backups <= 0 {
+		if err := w.file.Truncate(0); err != nil {
+			return err
+		}
+		w.size = 0
+		return nil
+	}
+
+	rotated := fmt.Sprintf("%s.%d", w.path, 1)
+	if w.compress {
+		// a backup left uncompressed by a failed gzip would be overwritten
+		// below, so compress it first or stop rotating until it can be
+		if _, err := os.Stat(rotated); err == nil {
+			if err := gzipFile(rotated); err != nil {
+				return err
+			}
+		}
+	}
+
+	// make room for the new backup; the oldest is overwritten
+	for n := w.backups - 1; n >= 1; n-- {
+		if err := os.Rename(w.backupName(n), w.backupName(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(w.path, rotated); err != nil {
+		return err
+	}
+	old := w.file
+	if err := w.open(); err != nil {
+		// keep writing to the renamed file rather than losing messages
+		return err
+	}
+	old.Close()
+
+	if w.compress {
+		return gzipFile(rotated)
+	}
+	return nil
+}
+
+// gzipFile compresses path to path.gz and removes path.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotatingFileWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := NewRotatingFileWriter(path, 10, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := readFile(t, path); got != "fourth\n" {
+		t.Errorf("expected the current file to hold fourth but got %q", got)
+	}
+	if got := readFile(t, path+".1"); got != "third\n" {
+		t.Errorf("expected the first backup to hold third but got %q", got)
+	}
+	if got := readFile(t, path+".2"); got != "second\n" {
+		t.Errorf("expected the second backup to hold second but got %q", got)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 backups to be kept, got %v", err)
+	}
+}
+
+func TestRotatingFileWriterCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := NewRotatingFileWriter(path, 10, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.Write([]byte("compressed\n"))
+	w.Write([]byte("current\n"))
+
+	f, err := os.Open(path + ".1.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(zr); err != nil || string(b) != "compressed\n" {
+		t.Errorf("expected the gzipped backup to hold compressed but got %q (%v)", b, err)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("expected the uncompressed backup to be removed, got %v", err)
+	}
+	if got := readFile(t, path); got != "current\n" {
+		t.Errorf("expected the current file to hold current but got %q", got)
+	}
+}
+
+func TestRotatingFileWriterUncompressedBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := NewRotatingFileWriter(path, 10, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// left behind by a rotation whose gzip failed
+	if err := os.WriteFile(path+".1", []byte("stale\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("compressed\n"))
+	w.Write([]byte("current\n"))
+
+	for name, want := range map[string]string{".1.gz": "compressed\n", ".2.gz": "stale\n"} {
+		f, err := os.Open(path + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := io.ReadAll(zr); err != nil || string(b) != want {
+			t.Errorf("expected %s to hold %q but got %q (%v)", name, want, b, err)
+		}
+		f.Close()
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("expected the uncompressed backup to be compressed, got %v", err)
+	}
+}
+
+func TestRotatingFileWriterFailedRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	w, err := NewRotatingFileWriter(path, 10, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// a directory in the way of the backup makes the rotation fail
+	if err := os.Mkdir(path+".1", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path+".1", "x"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w.Write([]byte("before\n"))
+	if _, err := w.Write([]byte("after\n")); err != nil {
+		t.Fatalf("expected the write to succeed despite the failed rotation, got %v", err)
+	}
+	if got := readFile(t, path); got != "before\nafter\n" {
+		t.Errorf("expected both lines in the current file but got %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// sink is a destination the writer goroutine writes rendered messages to.
+// Sinks count their own errors in errCount.
+type sink interface {
+	writeLog(msg *logMessage) error
+}
+
+var (
+	// sinks added with AddSink
+	sinks []sink
+
+	// levelOutputs are the outputs set with SetLevelOutput
+	levelOutputs = map[Level]io.Writer{}
+)
+
+// SetLevelOutput sends messages at level to w, one per line in the same
+// format as SetStdOut, instead of the default output. For example, Error and
+// Panic messages can go to stderr while the rest goes to stdout. Passing a
+// nil w sends the level back to the default output. It should be called
+// before logging starts.
+func SetLevelOutput(level Level, w io.Writer) {
+	if w == nil {
+		delete(levelOutputs, level)
+		return
+	}
+	levelOutputs[level] = w
+}
+
+// SetAccessOutput sends access messages, logged at Levels.Access such as by
+// Logger.Access, to w instead of the default output, for instance to keep
+// them in a file of their own for billing. It is SetLevelOutput for
+// Levels.Access, and passing a nil w likewise sends them back to the
+// default output. It should be called before logging starts.
+func SetAccessOutput(w io.Writer) {
+	SetLevelOutput(Levels.Access, w)
+}
+
+// AddSink adds an output that every message is written to, in addition to
+// the default output selected with SetStdOut, SetCustomSocket or syslog.
+// Messages are written one per line, in the same format as SetStdOut. It
+// should be called before logging starts.
+func AddSink(w io.Writer) {
+	sinks = append(sinks, writerSink{w})
+}
+
+// defaultSink returns the sink for the default output of msg.
+func defaultSink(msg *logMessage) sink {
+	if w, ok := levelOutputs[msg.le.lvl]; ok {
+		return writerSink{w}
+	}
+
+	switch {
+	case stdhdl != nil:
+		return writerSink{stdhdl}
+	case customSock == nil:
+		return syslogSink{}
+	default:
+		return socketSink{}
+	}
+}
+
+// writerSink writes messages to an io.Writer, one per line.
+type writerSink struct {
+	w io.Writer
+}
+
+func (s writerSink) writeLog(msg *logMessage) (err error) {
+	line := colorize(s.w, stdString(msg), msg.le.lvl)
+	if !strings.HasSuffix(line, "\n") { // see SetTrimNewlines
+		line += "\n"
+	}
+	if _, err = s.w.Write([]byte(line)); err != nil {
+		atomic.AddUint64(&errCount, 1)
+	}
+	return
+}
+
+// syslogSink writes messages to the local syslog.
+type syslogSink struct{}
+
+func (syslogSink) writeLog(msg *logMessage) error {
+	return write(msg)
+}
+
+// socketSink writes messages to the custom socket.
+type socketSink struct{}
+
+func (socketSink) writeLog(msg *logMessage) error {
+	if socketBatching() {
+		return batchSocketMsg(msg)
+	}
+	return writeWithRetries(writeCustomSocket, msg)
+}
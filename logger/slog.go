@@ -0,0 +1,102 @@
+//go:build go1.21
+// +build go1.21
+
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+)
+
+// slogHandler is a slog.Handler logging through a Logger.
+type slogHandler struct {
+	l      *Logger
+	fields []Field // from WithAttrs
+	group  string  // key prefix from WithGroup, with a trailing dot
+}
+
+// NewSlogHandler returns a slog.Handler that logs records through l, so
+// they are queued and written like any other message. Attributes become
+// fields, with group names joined to their keys by dots. Records at or above
+// slog.LevelError are logged at the Error level, at or above LevelWarn at
+// Warn, at or above LevelInfo at Info, and the rest at Debug.
+func NewSlogHandler(l *Logger) slog.Handler {
+	return &slogHandler{l: l}
+}
+
+// levelFromSlog maps a slog level to a Level.
+func levelFromSlog(level slog.Level) Level {
+	switch {
+	case level >= slog.LevelError:
+		return Levels.Error
+	case level >= slog.LevelWarn:
+		return Levels.Warn
+	case level >= slog.LevelInfo:
+		return Levels.Info
+	default:
+		return Levels.Debug
+	}
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.l.levelEnabled(levelFromSlog(level))
+}
+
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	level := levelFromSlog(r.Level)
+	if !h.l.keep(level) {
+		return nil
+	}
+
+	var caller logCaller
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		caller = logCaller{File: stripFile(frame.File), Line: frame.Line}
+	}
+
+	fields := h.fields
+	if r.NumAttrs() > 0 {
+		fields = make([]Field, len(h.fields), len(h.fields)+r.NumAttrs())
+		copy(fields, h.fields)
+		r.Attrs(func(a slog.Attr) bool {
+			fields = appendAttr(fields, h.group, a)
+			return true
+		})
+	}
+	return h.l.logAt(caller, level, "", "%s", []interface{}{r.Message}, true, fields)
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make([]Field, len(h.fields), len(h.fields)+len(attrs))
+	copy(fields, h.fields)
+	for _, a := range attrs {
+		fields = appendAttr(fields, h.group, a)
+	}
+	return &slogHandler{l: h.l, fields: fields, group: h.group}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{l: h.l, fields: h.fields, group: h.group + name + "."}
+}
+
+// appendAttr appends a as fields, flattening groups into dotted keys.
+func appendAttr(fields []Field, group string, a slog.Attr) []Field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			fields = appendAttr(fields, group, ga)
+		}
+		return fields
+	}
+	return append(fields, Field{group + a.Key, a.Value.Any()})
+}
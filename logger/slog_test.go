@@ -0,0 +1,35 @@
+//go:build go1.21
+// +build go1.21
+
+package logger
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogHandler(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	log := slog.New(NewSlogHandler(New(Levels.Info)))
+	log.Debug("hidden")
+	log.With("svc", "chf").WithGroup("req").Warn("slow", "ms", 250, slog.Group("peer", "ip", "10.0.0.1"))
+	log.Error("failed", slog.Group("", "flat", true))
+	Drain()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 messages but got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], "slog_test.go: 21> slow svc=chf req.ms=250 req.peer.ip=10.0.0.1") {
+		t.Errorf("unexpected warn message %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "slog_test.go: 22> failed flat=true") {
+		t.Errorf("unexpected error message %q", lines[1])
+	}
+}
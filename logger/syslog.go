@@ -0,0 +1,156 @@
+package logger
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+)
+
+// syslog facility and severities, as in syslog.h
+const (
+	logUser = 1 << 3
+
+	logErr     = 3
+	logWarning = 4
+	logInfo    = 6
+	logDebug   = 7
+)
+
+// Syslog facilities, as in syslog.h, for SetFacility and SetLevelFacility.
+// They are shifted left by three bits, ready to be combined with a severity.
+const (
+	FacilityUser   = 1 << 3
+	FacilityDaemon = 3 << 3
+	FacilityLocal0 = 16 << 3
+	FacilityLocal1 = 17 << 3
+	FacilityLocal2 = 18 << 3
+	FacilityLocal3 = 19 << 3
+	FacilityLocal4 = 20 << 3
+	FacilityLocal5 = 21 << 3
+	FacilityLocal6 = 22 << 3
+	FacilityLocal7 = 23 << 3
+)
+
+var (
+	// facility is the syslog facility of messages; see SetFacility
+	facility = logUser
+
+	// levelFacilities override facility by level; see SetLevelFacility
+	levelFacilities = map[Level]int{}
+)
+
+// SetFacility sets the syslog facility of messages, such as FacilityLocal0,
+// for the local syslog and the custom socket. It is FacilityUser by default.
+// It should be called before logging starts.
+func SetFacility(f int) {
+	facility = f
+}
+
+// SetLevelFacility sets the syslog facility of messages at level, in place
+// of the one set with SetFacility, so that for example errors can be routed
+// apart from the rest. A negative facility removes the override. It should be
+// called before logging starts.
+func SetLevelFacility(level Level, f int) {
+	if f < 0 {
+		delete(levelFacilities, level)
+		return
+	}
+	levelFacilities[level] = f
+}
+
+// priority returns the syslog priority of a message: its facility, from
+// SetLevelFacility, or else SetFacility, ORed with the severity of its
+// level. With the default FacilityUser (8), an error (severity 3) has
+// priority 11, written as "<11>".
+func priority(msg *logMessage) int {
+	f, ok := levelFacilities[msg.le.lvl]
+	if !ok {
+		f = facility
+	}
+	return f | msg.level
+}
+
+var (
+	// pureGoSyslog writes syslog messages to the local syslog socket
+	// directly instead of through the C library; see SetPureGoSyslog
+	pureGoSyslog bool
+
+	// syslogConn is the connection to the local syslog socket
+	syslogConn net.Conn
+
+	// syslogPaths are where the local syslog socket is looked for
+	syslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+)
+
+// SetPureGoSyslog writes syslog messages in Go, in the RFC 3164 format, to
+// the local syslog socket instead of calling the C library. The tag is the
+// name set with SetLogName. It returns an error if no local syslog socket can
+// be reached.
+func SetPureGoSyslog() error {
+	if err := dialSyslog(); err != nil {
+		return err
+	}
+	pureGoSyslog = true
+	return nil
+}
+
+// dialSyslog connects to the first local syslog socket that accepts a
+// connection.
+func dialSyslog() (err error) {
+	for _, path := range syslogPaths {
+		for _, network := range []string{"unixgram", "unix"} {
+			var conn net.Conn
+			if conn, err = net.Dial(network, path); err == nil {
+				syslogConn = conn
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// writeGoSyslog writes a message to the local syslog socket as
+//
+//	<PRI>Mmm dd hh:mm:ss TAG[PID]: MSG
+//
+// with a trailing newline for JSON messages. If the write fails, it
+// redials the socket and retries once.
+func writeGoSyslog(msg *logMessage) (err error) {
+	tag := logNameString
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+
+	b := make([]byte, 0, msg.Len()+len(tag)+32)
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(priority(msg)), 10)
+	b = append(b, '>')
+	b = msg.time.AppendFormat(b, "Jan _2 15:04:05 ")
+	b = append(b, tag...)
+	b = append(b, '[')
+	b = strconv.AppendInt(b, int64(os.Getpid()), 10)
+	b = append(b, "]: "...)
+	b = append(b, trimNewLines(string(msg.Bytes()[:msg.Len()-1]))...)
+	if sendJSON {
+		b = append(b, '\n') // one newline terminated object per message
+	}
+
+	if syslogConn == nil {
+		err = dialSyslog()
+	}
+	if err == nil {
+		if _, err = syslogConn.Write(b); err != nil {
+			syslogConn.Close()
+			syslogConn = nil
+			if err = dialSyslog(); err == nil {
+				_, err = syslogConn.Write(b)
+			}
+		}
+	}
+	if err != nil {
+		atomic.AddUint64(&errCount, 1)
+	}
+	return
+}
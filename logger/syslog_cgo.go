@@ -0,0 +1,61 @@
+//go:build cgo
+// +build cgo
+
+package logger
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// The csyslog function is necessary here because cgo does not appear
+// to be able to call a variadic function directly and syslog has the
+// same signature as printf.
+
+// #include <stdlib.h>
+// #ifdef _WIN32               /* for Windows builds, syslog functions do NOTHING */
+// void csyslog(int p, const char *m) {}
+// void openlog(const char *m, int i, int l) {}
+// #define	LOG_ERR		3      /* error conditions */
+// #define	LOG_WARNING	4      /* warning conditions */
+// #define	LOG_INFO	6      /* informational */
+// #define	LOG_DEBUG	7      /* debug-level messages */
+// #define	LOG_PID		0x01   /* log the pid with each message */
+// #define	LOG_NDELAY	0x08   /* don't delay open */
+// #define	LOG_NOWAIT	0x10   /* don't wait for console forks: DEPRECATED */
+// #define	LOG_USER	(1<<3) /* random user-level messages */
+// #else
+// #include <syslog.h>
+// void csyslog(int p, const char *m) {
+//     syslog(p, "%s", m);
+// }
+// #endif
+import "C"
+
+// logName is the syslog identifier, which openlog keeps a pointer to
+var logName *C.char
+
+// openSyslog opens the local syslog with name as the identifier.
+func openSyslog(name string) (err error) {
+	if logName != nil {
+		C.free(unsafe.Pointer(logName))
+	}
+	logName = C.CString(name)
+	_, err = C.openlog(logName, C.LOG_NDELAY|C.LOG_NOWAIT|C.LOG_PID, C.LOG_USER)
+	return err
+}
+
+// write function writes a message to syslog. This is a concrete, blocking event.
+// The priority passed to syslog carries the facility, overriding the one
+// given to openlog.
+func write(msg *logMessage) (err error) {
+	if pureGoSyslog {
+		return writeGoSyslog(msg)
+	}
+
+	start := (*C.char)(unsafe.Pointer(&msg.Bytes()[0]))
+	if _, err = C.csyslog(C.int(priority(msg)), start); err != nil {
+		atomic.AddUint64(&errCount, 1)
+	}
+	return
+}
@@ -0,0 +1,16 @@
+//go:build !cgo
+// +build !cgo
+
+package logger
+
+// openSyslog does nothing without cgo: the local syslog socket is dialed on
+// the first write.
+func openSyslog(name string) error {
+	return nil
+}
+
+// write writes a message to the local syslog socket. Without cgo, syslog is
+// always written in Go; see SetPureGoSyslog.
+func write(msg *logMessage) error {
+	return writeGoSyslog(msg)
+}
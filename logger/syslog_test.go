@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPureGoSyslog(t *testing.T) {
+	dir, err := os.MkdirTemp("", "golog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	defer func(paths []string, name string) {
+		syslogPaths, logNameString, pureGoSyslog = paths, name, false
+		if syslogConn != nil {
+			syslogConn.Close()
+			syslogConn = nil
+		}
+	}(syslogPaths, logNameString)
+	syslogPaths = []string{filepath.Join(dir, "missing"), path}
+	logNameString = "chf"
+
+	if err := SetPureGoSyslog(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &logMessage{level: 3, time: time.Date(2021, 5, 4, 3, 2, 1, 0, time.UTC)}
+	msg.WriteString("[Error] boom\n\x00")
+	if err := (syslogSink{}).writeLog(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	n, err := ln.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("<11>May  4 03:02:01 chf[%d]: [Error] boom", os.Getpid()); string(buf[:n]) != want {
+		t.Errorf("expected %q but got %q", want, buf[:n])
+	}
+}
+
+func TestSetLevelFacility(t *testing.T) {
+	defer SetFacility(FacilityUser)
+	defer SetLevelFacility(Levels.Error, -1)
+	defer SetSyslogLegacyFormat(false)
+	SetSyslogLegacyFormat(true)
+
+	frame := func(lvl Level) string {
+		msg := &logMessage{le: logEntry{lvl: lvl, fmt: "m"}}
+		if err := render(msg); err != nil {
+			t.Fatal(err)
+		}
+		b := customSocketFrame(msg)
+		return string(b[:bytes.IndexByte(b, '>')+1])
+	}
+
+	if got := frame(Levels.Error); got != "<11>" {
+		t.Errorf("expected the user facility by default but got %q", got)
+	}
+
+	SetFacility(FacilityLocal1)
+	SetLevelFacility(Levels.Error, FacilityLocal0)
+	for lvl, want := range map[Level]string{Levels.Error: "<131>", Levels.Info: "<142>", Levels.Debug: "<143>"} {
+		if got := frame(lvl); got != want {
+			t.Errorf("%v: expected priority %q but got %q", lvl, want, got)
+		}
+	}
+
+	SetLevelFacility(Levels.Error, -1)
+	if got := frame(Levels.Error); got != "<139>" {
+		t.Errorf("expected the global facility once the override is removed but got %q", got)
+	}
+}
@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	// timeLayout formats message timestamps in place of STDOUT_FORMAT, and
+	// of RFC 3339 in JSON, when set; see SetTimeFormat
+	timeLayout string
+
+	// useUTC stamps messages in UTC instead of local time; see SetUTC
+	useUTC bool
+)
+
+// SetTimeFormat sets the time layout, as in time.Format, of message
+// timestamps, for instance time.RFC3339Nano. It is used by both the string
+// and JSON formats. An empty layout restores the defaults: STDOUT_FORMAT for
+// strings and RFC 3339 for JSON. Layouts without any time element are
+// rejected.
+func SetTimeFormat(layout string) error {
+	if layout != "" {
+		ref := time.Date(2021, 5, 4, 3, 2, 1, 0, time.UTC)
+		s := ref.Format(layout)
+		if s == layout {
+			return fmt.Errorf("invalid time format %q: no time elements", layout)
+		}
+		if _, err := time.Parse(layout, s); err != nil {
+			return fmt.Errorf("invalid time format %q: %v", layout, err)
+		}
+	}
+	timeLayout = layout
+	return nil
+}
+
+// SetUTC stamps messages in UTC instead of local time, so logs from hosts
+// in different zones line up.
+func SetUTC(utc bool) {
+	useUTC = utc
+}
+
+// jsonTime marshals a message time in the layout set with SetTimeFormat.
+type jsonTime time.Time
+
+// MarshalJSON implements json.Marshaler.
+func (t jsonTime) MarshalJSON() ([]byte, error) {
+	if timeLayout == "" {
+		return time.Time(t).MarshalJSON()
+	}
+	b := append(make([]byte, 0, len(timeLayout)+10), '"')
+	b = time.Time(t).AppendFormat(b, timeLayout)
+	return append(b, '"'), nil
+}
+
+// appendTimestamp appends t formatted as STDOUT_FORMAT to b. It produces the
+// same output as t.AppendFormat(b, STDOUT_FORMAT) but writes the digits
+// directly, which is several times faster than the general layout parser.
+func appendTimestamp(b []byte, t time.Time) []byte {
+	year, month, day := t.Date()
+	if year < 0 || year > 9999 {
+		return t.AppendFormat(b, STDOUT_FORMAT)
+	}
+	hour, min, sec := t.Clock()
+	millis := t.Nanosecond() / int(time.Millisecond)
+
+	b = appendDigits(b, year, 4)
+	b = append(b, '-')
+	b = appendDigits(b, int(month), 2)
+	b = append(b, '-')
+	b = appendDigits(b, day, 2)
+	b = append(b, 'T')
+	b = appendDigits(b, hour, 2)
+	b = append(b, ':')
+	b = appendDigits(b, min, 2)
+	b = append(b, ':')
+	b = appendDigits(b, sec, 2)
+	b = append(b, '.')
+	b = appendDigits(b, millis, 3)
+	return append(b, ' ')
+}
+
+// appendDigits appends the n least significant decimal digits of v to b,
+// zero padded.
+func appendDigits(b []byte, v, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, 0)
+	}
+	for i := len(b) - 1; n > 0; i, n = i-1, n-1 {
+		b[i] = byte('0' + v%10)
+		v /= 10
+	}
+	return b
+}
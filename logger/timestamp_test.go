@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_appendTimestamp(t *testing.T) {
+	times := []time.Time{
+		time.Date(2021, 1, 2, 3, 4, 5, 6000000, time.UTC),
+		time.Date(1999, 12, 31, 23, 59, 59, 999999999, time.Local),
+		time.Date(5, 6, 7, 8, 9, 10, 0, time.UTC),
+		time.Date(-1, 6, 7, 8, 9, 10, 0, time.UTC),
+		time.Date(12345, 6, 7, 8, 9, 10, 0, time.UTC),
+		time.Now(),
+	}
+	for i := 0; i < 1000; i++ {
+		times = append(times, time.Unix(rand.Int63n(1<<35), rand.Int63n(int64(time.Second))))
+	}
+
+	for _, tm := range times {
+		want := tm.Format(STDOUT_FORMAT)
+		if got := string(appendTimestamp(nil, tm)); got != want {
+			t.Errorf("expected %q but got %q", want, got)
+		}
+	}
+}
+
+func Benchmark_appendTimestamp(b *testing.B) {
+	tm := time.Now()
+	buf := make([]byte, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = appendTimestamp(buf[:0], tm)
+	}
+}
+
+func Benchmark_timeAppendFormat(b *testing.B) {
+	tm := time.Now()
+	buf := make([]byte, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = tm.AppendFormat(buf[:0], STDOUT_FORMAT)
+	}
+}
+
+func TestSetTimeFormat(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	defer SetTimeSource(nil)
+	defer SetTimeFormat("")
+	defer SetUTC(false)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+
+	for _, layout := range []string{"not a layout", "2006-13-02"} {
+		if err := SetTimeFormat(layout); err == nil {
+			t.Errorf("expected %q to be rejected", layout)
+		}
+	}
+
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	SetTimeSource(func() time.Time { return time.Date(2021, 5, 4, 3, 2, 1, 5, zone) })
+	if err := SetTimeFormat(time.RFC3339Nano); err != nil {
+		t.Fatal(err)
+	}
+	SetUTC(true)
+
+	log := New(Levels.Info)
+	log.Infof("", "string")
+	Drain()
+	if want := "2021-05-04T01:02:01.000000005Z "; !strings.HasPrefix(buf.String(), want) {
+		t.Errorf("expected the message stamped %q but got %q", want, buf.String())
+	}
+
+	msg := &logMessage{time: time.Date(2021, 5, 4, 1, 2, 1, 5, time.UTC)}
+	if err := asJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	if want := `"time":"2021-05-04T01:02:01.000000005Z"`; !strings.Contains(msg.String(), want) {
+		t.Errorf("expected %s in %s", want, msg.String())
+	}
+}
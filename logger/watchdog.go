@@ -0,0 +1,115 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxWatchdogRestarts is how many times in a row the watchdog restarts the
+// writer before giving up.
+const maxWatchdogRestarts = 10
+
+// errWriterExited is handed to the watchdog when the writer goroutine exits
+// without a panic, such as when an output calls runtime.Goexit.
+var errWriterExited = errors.New("log writer exited")
+
+var (
+	watchdogEnabled int32 // atomic; 1 once EnableWriterWatchdog is called
+	watchdogOnce    sync.Once
+
+	// writerCrashed receives what a panicking writer goroutine recovered,
+	// or errWriterExited
+	writerCrashed = make(chan interface{}, 1)
+
+	// watchdogBackoff is the wait before the first restart, doubling after
+	// each one
+	watchdogBackoff = 100 * time.Millisecond
+
+	// watchdogHealthy is how long a restarted writer must run for the
+	// watchdog to start over with watchdogBackoff and a new count of
+	// restarts
+	watchdogHealthy = time.Minute
+
+	// writerRestarts counts the restarts of the watchdog; atomic
+	writerRestarts uint64
+
+	// lastWriterBeat is when the writer goroutine last picked up or finished
+	// a message, in Unix nanoseconds; atomic
+	lastWriterBeat int64
+)
+
+// writerBeat records that the writer goroutine is alive, and whether it is
+// busy writing or waiting for messages; see WriterStalled and inWriter.
+func writerBeat(busy bool) {
+	atomic.StoreInt64(&lastWriterBeat, time.Now().UnixNano())
+	var b int32
+	if busy {
+		b = 1
+	}
+	atomic.StoreInt32(&writerBusy, b)
+}
+
+// WriterStalled reports whether the writer goroutine has had messages to
+// write but hasn't made progress for longer than threshold, such as when it
+// is stuck on a blocking output, or stopped after a panic. An idle writer is
+// not stalled. It lets a health check detect a wedged logger.
+func WriterStalled(threshold time.Duration) bool {
+	if len(messages) == 0 && atomic.LoadInt32(&writerBusy) == 0 {
+		return false
+	}
+	return time.Since(time.Unix(0, atomic.LoadInt64(&lastWriterBeat))) > threshold
+}
+
+// WriterRestarts returns the number of times the watchdog restarted the
+// writer goroutine; see EnableWriterWatchdog.
+func WriterRestarts() uint64 {
+	return atomic.LoadUint64(&writerRestarts)
+}
+
+// EnableWriterWatchdog makes the writer goroutine recover from panics, for
+// instance in a misbehaving output, and restarts it, as well as when it
+// exits without Close. Without it, such a panic crashes the program. The
+// incident is reported on stderr and logged once the writer is back.
+// Restarts back off exponentially, and the watchdog gives up after
+// maxWatchdogRestarts in a row, leaving logging stopped; once the writer
+// has run for watchdogHealthy, the backoff and the count start over.
+func EnableWriterWatchdog() {
+	watchdogOnce.Do(func() {
+		atomic.StoreInt32(&watchdogEnabled, 1)
+		go watchWriter()
+	})
+}
+
+// watchWriter restarts the writer goroutine every time it panics or exits.
+func watchWriter() {
+	backoff, restarts := watchdogBackoff, 0
+	var restarted time.Time
+	for {
+		r := <-writerCrashed
+		if time.Since(restarted) >= watchdogHealthy {
+			backoff, restarts = watchdogBackoff, 0
+		}
+		reason := fmt.Sprintf("panic: %v", r)
+		if r == errWriterExited {
+			reason = "unexpected exit"
+		}
+		if restarts == maxWatchdogRestarts {
+			fmt.Fprintf(os.Stderr, "log writer stopped after %s; not restarting after %d restarts\n", reason, maxWatchdogRestarts)
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "log writer stopped after %s; restarting in %v\n", reason, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		restarts++
+		restarted = time.Now()
+
+		atomic.AddUint64(&writerRestarts, 1)
+		go logWriter()
+		LogNoTee(Levels.Error, "[meta log] ", "log writer restarted after %s", reason)
+	}
+}
@@ -0,0 +1,136 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// panickyWriter panics on its first write containing on.
+type panickyWriter struct {
+	on       string
+	panicked bool
+}
+
+func (w *panickyWriter) Write(p []byte) (int, error) {
+	if !w.panicked && strings.Contains(string(p), w.on) {
+		w.panicked = true
+		panic("broken output")
+	}
+	return len(p), nil
+}
+
+func TestWriterWatchdog(t *testing.T) {
+	defer func(origstdhdl io.Writer, origSinks []sink) { stdhdl, sinks = origstdhdl, origSinks }(stdhdl, sinks)
+	defer func(orig time.Duration) { watchdogBackoff = orig }(watchdogBackoff)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+	watchdogBackoff = time.Millisecond
+
+	EnableWriterWatchdog()
+	AddSink(&panickyWriter{})
+
+	log := New(Levels.Debug)
+	log.Infof("", "lost in the panic")
+	time.Sleep(50 * time.Millisecond) // let the watchdog restart the writer
+	log.Infof("", "after the restart")
+	Drain()
+
+	if !strings.Contains(buf.String(), "log writer restarted after panic: broken output") {
+		t.Errorf("expected the restart to be logged but got %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "after the restart\n") {
+		t.Errorf("expected logging to work after the restart but got %q", buf.String())
+	}
+}
+
+// exitingWriter ends the goroutine calling it on its first write.
+type exitingWriter struct{ exited bool }
+
+func (w *exitingWriter) Write(p []byte) (int, error) {
+	if !w.exited {
+		w.exited = true
+		runtime.Goexit()
+	}
+	return len(p), nil
+}
+
+func TestWriterWatchdogExit(t *testing.T) {
+	defer func(origstdhdl io.Writer, origSinks []sink) { stdhdl, sinks = origstdhdl, origSinks }(stdhdl, sinks)
+	defer func(orig time.Duration) { watchdogBackoff = orig }(watchdogBackoff)
+	buf := bytes.Buffer{}
+	stdhdl = &buf
+	watchdogBackoff = time.Millisecond
+
+	EnableWriterWatchdog()
+	AddSink(&exitingWriter{})
+
+	log := New(Levels.Debug)
+	log.Infof("", "lost in the exit")
+	time.Sleep(50 * time.Millisecond) // let the watchdog restart the writer
+	log.Infof("", "after the restart")
+	Drain()
+
+	if !strings.Contains(buf.String(), "log writer restarted after unexpected exit") {
+		t.Errorf("expected the restart to be logged but got %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "after the restart\n") {
+		t.Errorf("expected logging to work after the restart but got %q", buf.String())
+	}
+}
+
+func TestWriterWatchdogHealthy(t *testing.T) {
+	defer func(origstdhdl io.Writer, origSinks []sink) { stdhdl, sinks = origstdhdl, origSinks }(stdhdl, sinks)
+	defer func(orig time.Duration) { watchdogBackoff = orig }(watchdogBackoff)
+	defer func(orig time.Duration) { watchdogHealthy = orig }(watchdogHealthy)
+	stdhdl = io.Discard
+	watchdogBackoff = time.Millisecond
+	watchdogHealthy = 5 * time.Millisecond
+
+	for i := 0; i < maxWatchdogRestarts+2; i++ {
+		AddSink(&panickyWriter{on: fmt.Sprintf("crash %d\n", i)})
+	}
+
+	EnableWriterWatchdog()
+	before := WriterRestarts()
+	log := New(Levels.Debug)
+	for i := 0; i < maxWatchdogRestarts+2; i++ {
+		log.Infof("", "crash %d", i)
+		time.Sleep(20 * time.Millisecond) // restarted, then healthy again
+	}
+	Drain()
+
+	if restarts := WriterRestarts() - before; restarts != maxWatchdogRestarts+2 {
+		t.Errorf("expected the watchdog to keep restarting a writer that runs fine in between but got %d restarts", restarts)
+	}
+}
+
+func TestWriterStalled(t *testing.T) {
+	defer func(origstdhdl io.Writer) { stdhdl = origstdhdl }(stdhdl)
+	w := &blockingWriter{release: make(chan struct{})}
+	stdhdl = w
+
+	if WriterStalled(10 * time.Millisecond) {
+		t.Error("expected an idle writer not to be stalled")
+	}
+
+	log := New(Levels.Info)
+	log.Infof("", "stuck")
+	time.Sleep(50 * time.Millisecond)
+	if !WriterStalled(10 * time.Millisecond) {
+		t.Error("expected a writer blocked on its output to be stalled")
+	}
+	if WriterStalled(time.Minute) {
+		t.Error("expected a writer blocked for less than the threshold not to be stalled")
+	}
+
+	close(w.release)
+	Drain()
+	if WriterStalled(10 * time.Millisecond) {
+		t.Error("expected the writer not to be stalled once it caught up")
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/kentik/golog/logmetrics
+
+go 1.16
+
+require (
+	github.com/kentik/golog v0.0.0
+	github.com/prometheus/client_golang v1.11.0
+)
+
+replace github.com/kentik/golog => ../
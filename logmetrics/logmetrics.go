@@ -0,0 +1,46 @@
+// Package logmetrics exports the counters of the logger package as
+// Prometheus metrics. It is a separate module so the logger itself doesn't
+// depend on the Prometheus client.
+package logmetrics
+
+import (
+	"github.com/kentik/golog/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	logsDesc = prometheus.NewDesc("golog_logs_total",
+		"Number of messages logged since startup.", nil, nil)
+	droppedDesc = prometheus.NewDesc("golog_dropped_total",
+		"Number of messages dropped because the message pool was exhausted.", nil, nil)
+	errorsDesc = prometheus.NewDesc("golog_errors_total",
+		"Number of errors seen while writing messages.", nil, nil)
+	pendingDesc = prometheus.NewDesc("golog_pending_messages",
+		"Number of messages queued to be written.", nil, nil)
+)
+
+// collector reads the logger counters on every scrape.
+type collector struct{}
+
+// MetricsCollector returns a collector exposing the counters of
+// logger.Stats. Register it with any registry:
+//
+//	prometheus.MustRegister(logmetrics.MetricsCollector())
+func MetricsCollector() prometheus.Collector {
+	return collector{}
+}
+
+func (collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- logsDesc
+	ch <- droppedDesc
+	ch <- errorsDesc
+	ch <- pendingDesc
+}
+
+func (collector) Collect(ch chan<- prometheus.Metric) {
+	logs, pending, drop, errs := logger.Stats()
+	ch <- prometheus.MustNewConstMetric(logsDesc, prometheus.CounterValue, float64(logs))
+	ch <- prometheus.MustNewConstMetric(droppedDesc, prometheus.CounterValue, float64(drop))
+	ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.CounterValue, float64(errs))
+	ch <- prometheus.MustNewConstMetric(pendingDesc, prometheus.GaugeValue, float64(pending))
+}
@@ -0,0 +1,49 @@
+package logmetrics
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/kentik/golog/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricsCollector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(MetricsCollector()); err != nil {
+		t.Fatal(err)
+	}
+
+	logger.New(logger.Levels.Info).Infof("", "counted")
+	logger.Drain()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	var names []string
+	for _, mf := range families {
+		names = append(names, mf.GetName())
+		m := mf.GetMetric()[0]
+		if m.GetCounter() != nil {
+			values[mf.GetName()] = m.GetCounter().GetValue()
+		} else {
+			values[mf.GetName()] = m.GetGauge().GetValue()
+		}
+	}
+	sort.Strings(names)
+
+	want := []string{"golog_dropped_total", "golog_errors_total", "golog_logs_total", "golog_pending_messages"}
+	if len(names) != len(want) {
+		t.Fatalf("expected metrics %q but got %q", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected metrics %q but got %q", want, names)
+		}
+	}
+	if values["golog_logs_total"] < 1 {
+		t.Errorf("expected at least one log counted but got %v", values["golog_logs_total"])
+	}
+}